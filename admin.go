@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/julienschmidt/httprouter"
+)
+
+// AdminToken is the token required by the admin endpoints, the admin endpoints are disabled when it is empty.
+var AdminToken string
+
+// adminOnly guards the handler by the admin token, in header `Authorization: Bearer {token}`.
+func adminOnly(h func(http.ResponseWriter, *http.Request, httprouter.Params) error) func(http.ResponseWriter, *http.Request, httprouter.Params) error {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+		if AdminToken == "" {
+			return &StatusError{Code: http.StatusForbidden, Err: fmt.Errorf("admin endpoints are disabled, start with -admin-token to enable them")}
+		}
+		if r.Header.Get("Authorization") != "Bearer "+AdminToken {
+			return &StatusError{Code: http.StatusUnauthorized, Err: fmt.Errorf("invalid admin token")}
+		}
+		return h(w, r, p)
+	}
+}
+
+// AdminWrite writes the mobile+label key with the request body as value to exactly the partition
+// given by the query param, bypassing the hash partitioning.
+// UNSAFE: the key is unreachable by the normal queries if the partition is not the hashed one,
+// it is only for deterministic tests and replaying exports produced under a different partition scheme.
+func (s *pebbleDB) AdminWrite(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	q := r.URL.Query()
+	partition, err := strconv.ParseUint(q.Get("partition"), 10, 64)
+	if err != nil {
+		return err
+	}
+	if partition >= uint64(len(s.dbs)) {
+		return fmt.Errorf("partition %d out of range [0, %d)", partition, len(s.dbs))
+	}
+
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	mobile, err := mobile2bytes(q.Get("mobile"))
+	if err != nil {
+		return err
+	}
+	mobile = ds.key(mobile)
+	label := NormalizeLabel(q.Get("label"))
+	if label == "" {
+		return fmt.Errorf("label is required")
+	}
+
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetPartition(partition, append(mobile, label...), value); err != nil {
+		return err
+	}
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "partition": partition})
+}
+
+// CompactThroughput is the assumed bytes per second a compaction rewrites, to estimate the compaction duration.
+var CompactThroughput = 64 << 20
+
+// CompactEstimate is the dry estimate of a full compaction of a partition.
+type CompactEstimate struct {
+	Partition      int    `json:"partition"`
+	DiskUsage      uint64 `json:"diskUsage"`
+	TableSize      uint64 `json:"tableSize"`
+	CompactionDebt uint64 `json:"compactionDebt"`
+	Entries        uint64 `json:"entries"`
+	Deletions      uint64 `json:"deletions"`
+	LiveKeys       uint64 `json:"liveKeys"`
+	EstimatedSize  uint64 `json:"estimatedSize"`
+	Reclaimable    uint64 `json:"reclaimable"`
+	Duration       string `json:"duration"`
+}
+
+// CompactEstimate reports per partition how much a full compaction would reclaim and how long it would take,
+// without compacting anything.
+func (s *pebbleDB) CompactEstimate(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+	start := time.Now()
+	estimates := make([]CompactEstimate, len(s.dbs))
+	for i := range s.dbs {
+		e, err := s.estimateCompact(i)
+		if err != nil {
+			return err
+		}
+		estimates[i] = e
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "partitions": estimates})
+}
+
+// estimateCompact estimates the size after a full compaction by scaling the table size with the ratio of
+// the live entries to all the entries in the tables, by the table properties and the metrics only, without scanning the keys.
+// Each point deletion is counted to drop itself and the entry it shadows, while the overwrites of the same keys
+// in different levels can not be told apart, so the estimate is conservative after reloads.
+func (s *pebbleDB) estimateCompact(partition int) (CompactEstimate, error) {
+	db := s.dbs[partition]
+	m := db.Metrics()
+	e := CompactEstimate{
+		Partition:      partition,
+		DiskUsage:      m.DiskSpaceUsage(),
+		CompactionDebt: m.Compact.EstimatedDebt,
+	}
+	for _, l := range m.Levels {
+		e.TableSize += uint64(l.Size)
+	}
+
+	tables, err := db.SSTables(pebble.WithProperties())
+	if err != nil {
+		return e, err
+	}
+	for _, level := range tables {
+		for _, t := range level {
+			e.Entries += t.Properties.NumEntries
+			e.Deletions += t.Properties.NumPointDeletions()
+		}
+	}
+	if e.Entries > 2*e.Deletions {
+		e.LiveKeys = e.Entries - 2*e.Deletions
+	}
+
+	e.EstimatedSize = e.TableSize
+	if e.Entries > 0 {
+		e.EstimatedSize = uint64(float64(e.TableSize) * float64(e.LiveKeys) / float64(e.Entries))
+	}
+	e.Reclaimable = e.TableSize - e.EstimatedSize
+	e.Duration = (time.Duration(e.TableSize) * time.Second / time.Duration(CompactThroughput)).String()
+	return e, nil
+}
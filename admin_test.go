@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// adminRequest serves the admin request with the token.
+func adminRequest(t *testing.T, db *pebbleDB, method, target, body, token string, out any) (code int, errMsg string) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return serve(t, db, r, out)
+}
+
+func TestAdminWriteToPartition(t *testing.T) {
+	db := openTestDB(t, 4)
+	setGlobal(t, &AdminToken, "secret")
+
+	mobile, _ := mobile2bytes("13800000001")
+	// forces the key to a partition other than the hashed one.
+	forced := (db.Partition(mobile) + 1) % 4
+	target := "/admin/write?partition=" + strconv.FormatUint(forced, 10) + "&mobile=13800000001&label=vip"
+
+	var res struct {
+		Partition uint64 `json:"partition"`
+	}
+	if code, _ := adminRequest(t, db, http.MethodPost, target, "v=1", "secret", &res); code != http.StatusOK || res.Partition != forced {
+		t.Fatalf("admin write status %d, partition %d, want 200 of partition %d", code, res.Partition, forced)
+	}
+	db.Barrier()
+
+	v, closer, err := db.dbs[forced].Get(append(mobile, "vip"...))
+	if err != nil {
+		t.Fatalf("key not found in the forced partition %d: %v", forced, err)
+	}
+	if string(v) != "v=1" {
+		t.Errorf("value %q, want v=1", v)
+	}
+	closer.Close()
+
+	// the key is unreachable by the normal query, which reads the hashed partition.
+	if got := labelsOf(t, db, "13800000001"); len(got) != 0 {
+		t.Errorf("labels of 13800000001 are %v, want none from the hashed partition", got)
+	}
+}
+
+func TestAdminWriteGuard(t *testing.T) {
+	db := openTestDB(t, 4)
+	target := "/admin/write?partition=0&mobile=13800000001&label=vip"
+
+	setGlobal(t, &AdminToken, "")
+	if code, _ := adminRequest(t, db, http.MethodPost, target, "", "", nil); code != http.StatusForbidden {
+		t.Errorf("status %d without the admin token configured, want 403", code)
+	}
+
+	setGlobal(t, &AdminToken, "secret")
+	if code, _ := adminRequest(t, db, http.MethodPost, target, "", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("status %d of the wrong token, want 401", code)
+	}
+	if code, _ := adminRequest(t, db, http.MethodPost, "/admin/write?partition=4&mobile=13800000001&label=vip", "", "secret", nil); code == http.StatusOK {
+		t.Error("the partition out of range should fail")
+	}
+}
+
+func TestCompactEstimate(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 2)
+	setGlobal(t, &AdminToken, "secret")
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 1000))
+
+	// the reload and the removals leave the obsolete entries in the flushed tables.
+	load(t, db, "a.txt", "vip", "durable=y")
+	load(t, db, "a.txt", "vip", "durable=y&batch_id=b2")
+	for i := 0; i < 100; i++ {
+		mustRequest(t, db, http.MethodDelete, "/labels/"+strconv.Itoa(13800000000+i)+"/vip", "", nil)
+	}
+	if err := db.Sync([]uint64{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var res struct {
+		Partitions []CompactEstimate `json:"partitions"`
+	}
+	if code, errMsg := adminRequest(t, db, http.MethodGet, "/admin/compact/estimate", "", "secret", &res); code != http.StatusOK {
+		t.Fatalf("status %d, error %s", code, errMsg)
+	}
+	if len(res.Partitions) != 2 {
+		t.Fatalf("%d partitions estimated, want 2", len(res.Partitions))
+	}
+	var deletions uint64
+	for i, e := range res.Partitions {
+		if e.Partition != i || e.DiskUsage == 0 || e.TableSize == 0 || e.Duration == "" {
+			t.Errorf("estimate %+v, want the usage and the sizes populated", e)
+		}
+		if e.EstimatedSize >= e.TableSize || e.Reclaimable != e.TableSize-e.EstimatedSize {
+			t.Errorf("estimate %+v, want some of the tables reclaimable", e)
+		}
+		if e.LiveKeys != e.Entries-2*e.Deletions {
+			t.Errorf("estimate %+v, want the live keys of the entries without the deletions and the shadowed ones", e)
+		}
+		deletions += e.Deletions
+	}
+	// the tables of the loads and the removals are flushed to L0, below the threshold to compact them.
+	if deletions != 100 {
+		t.Errorf("%d deletions in the tables, want the 100 removals", deletions)
+	}
+}
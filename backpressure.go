@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BacklogHighWater is the high-water mark of the aggregate backlog of the op channels,
+// requests are rejected with 503 when the backlog exceeds it, 0 to disable.
+var BacklogHighWater = 0
+
+// BacklogRetryAfter is the seconds in the Retry-After header of the rejected requests.
+var BacklogRetryAfter = 1
+
+// Backlog returns the aggregate number of the ops queued in all the op channels.
+func (s *pebbleDB) Backlog() (n int) {
+	for _, c := range s.dbc {
+		n += len(c)
+	}
+	return n
+}
+
+// backpressure rejects the request with 503 when the op channels are saturated, so clients back off instead of piling on.
+func (s *pebbleDB) backpressure(h func(http.ResponseWriter, *http.Request, httprouter.Params) error) func(http.ResponseWriter, *http.Request, httprouter.Params) error {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+		if BacklogHighWater > 0 {
+			if backlog := s.Backlog(); backlog > BacklogHighWater {
+				w.Header().Set("Retry-After", strconv.Itoa(BacklogRetryAfter))
+				return &StatusError{
+					Code: http.StatusServiceUnavailable,
+					Err:  fmt.Errorf("too busy, op backlog %d exceeds high-water mark %d", backlog, BacklogHighWater),
+				}
+			}
+		}
+		return h(w, r, p)
+	}
+}
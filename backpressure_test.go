@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackpressure(t *testing.T) {
+	setGlobal(t, &BacklogHighWater, 4)
+	setGlobal(t, &BacklogRetryAfter, 3)
+
+	// the op channels without the consumers stay saturated.
+	saturated := &pebbleDB{dbc: []chan op{make(chan op, 4), make(chan op, 4)}}
+	for _, c := range saturated.dbc {
+		for i := 0; i < 3; i++ {
+			c <- op{typ: opSet}
+		}
+	}
+	if n := saturated.Backlog(); n != 6 {
+		t.Fatalf("backlog %d, want 6", n)
+	}
+
+	for _, req := range []struct{ method, target string }{
+		{http.MethodPost, "/load/a.txt/vip"},
+		{http.MethodGet, "/labels/13800000001"},
+	} {
+		w := httptest.NewRecorder()
+		newRouter(saturated).ServeHTTP(w, httptest.NewRequest(req.method, req.target, nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+			t.Errorf("%s %s: status %d, Retry-After %q, want 503 of 3", req.method, req.target, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	// the requests pass below the high-water mark.
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	load(t, db, "a.txt", "vip", "")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BatchRequest is the request body of the batch query.
+type BatchRequest struct {
+	Mobiles []string `json:"mobiles"`
+}
+
+// BatchResult is the labels of a mobile in the batch query.
+type BatchResult struct {
+	Mobile string   `json:"mobile"`
+	Labels []string `json:"labels"`
+	// Partition is the partition the mobile is routed to, only with debug=true.
+	Partition *uint64 `json:"partition,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// MaxBatchResponseBytes caps the approximate size of the results of a batch query, 0 for unlimited.
+// The results beyond it are omitted, and the response lists the omitted mobiles to re-request in smaller batches.
+var MaxBatchResponseBytes = 0
+
+// GetLabels queries the labels of the mobiles in batch, with debug=true annotating each mobile with its partition.
+func (s *pebbleDB) GetLabels(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	start := time.Now()
+	debug := IsBool(r.URL.Query().Get("debug"))
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+
+	results := make([]BatchResult, 0, len(req.Mobiles))
+	var size int
+	var omitted []string
+	for i, m := range req.Mobiles {
+		results = append(results, BatchResult{Mobile: m})
+		mobile, err := mobile2bytes(m)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		mobile = ds.key(mobile)
+
+		if debug {
+			partition := s.Partition(mobile)
+			results[i].Partition = &partition
+		}
+		if results[i].Labels, err = s.FindLabelsByMobile(mobile); err != nil {
+			return err
+		}
+
+		if MaxBatchResponseBytes > 0 {
+			b, _ := json.Marshal(results[i])
+			// the first result is always kept, so that the client makes progress by re-requesting the omitted ones.
+			if size += len(b); size > MaxBatchResponseBytes && i > 0 {
+				results = results[:i]
+				omitted = req.Mobiles[i:]
+				break
+			}
+		}
+	}
+
+	cost := time.Since(start)
+	body := H{"cost": cost.String(), "results": results}
+	if omitted != nil {
+		body["truncated"] = true
+		body["omitted"] = omitted
+	}
+	return jsonResponse(w, body)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type batchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Truncated bool          `json:"truncated"`
+	Omitted   []string      `json:"omitted"`
+}
+
+func TestBatchDebugPartitions(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 8)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n")
+	load(t, db, "a.txt", "vip", "")
+
+	body := `{"mobiles":["13800000001","13800000002","13800000003","13912345678","abc"]}`
+	var res batchResponse
+	mustRequest(t, db, http.MethodPost, "/labels?debug=true", body, &res)
+	if len(res.Results) != 5 {
+		t.Fatalf("%d results, want 5", len(res.Results))
+	}
+	for _, r := range res.Results[:4] {
+		mobile, _ := mobile2bytes(r.Mobile)
+		if r.Partition == nil || *r.Partition != db.Partition(mobile) {
+			t.Errorf("mobile %s annotated with partition %v, want %d", r.Mobile, r.Partition, db.Partition(mobile))
+		}
+	}
+	if r := res.Results[0]; len(r.Labels) != 1 || r.Labels[0] != "vip" {
+		t.Errorf("labels of %s are %v, want [vip]", r.Mobile, r.Labels)
+	}
+	if r := res.Results[4]; r.Partition != nil || !strings.Contains(r.Error, "bad mobile") {
+		t.Errorf("result of the bad mobile %+v, want the error without partition", r)
+	}
+
+	var plain batchResponse
+	mustRequest(t, db, http.MethodPost, "/labels", body, &plain)
+	for _, r := range plain.Results {
+		if r.Partition != nil {
+			t.Errorf("mobile %s annotated with partition %d without debug", r.Mobile, *r.Partition)
+		}
+	}
+}
+
+func TestBatchResponseCap(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 5))
+	load(t, db, "a.txt", "vip", "")
+	mobiles := strings.Split(strings.TrimSpace(mobilesFile(13800000001, 5)), "\n")
+
+	// each result is {"mobile":"13800000001","labels":["vip"]} of 45 bytes.
+	setGlobal(t, &MaxBatchResponseBytes, 100)
+	var got []string
+	for pending, requests := mobiles, 0; len(pending) > 0; requests++ {
+		if requests == 5 {
+			t.Fatalf("still %d mobiles omitted after 5 requests", len(pending))
+		}
+		var res batchResponse
+		mustRequest(t, db, http.MethodPost, "/labels", `{"mobiles":["`+strings.Join(pending, `","`)+`"]}`, &res)
+		if len(pending) > 2 && (!res.Truncated || len(res.Results) != 2) {
+			t.Errorf("truncated %t with %d results of %d mobiles, want 2 results truncated", res.Truncated, len(res.Results), len(pending))
+		}
+		for _, r := range res.Results {
+			got = append(got, r.Mobile)
+		}
+		pending = res.Omitted
+	}
+	if strings.Join(got, ",") != strings.Join(mobiles, ",") {
+		t.Errorf("results of %v by re-requesting the omitted, want %v", got, mobiles)
+	}
+
+	// the first result is kept even beyond the cap.
+	setGlobal(t, &MaxBatchResponseBytes, 10)
+	var res batchResponse
+	mustRequest(t, db, http.MethodPost, "/labels", `{"mobiles":["13800000001","13800000002"]}`, &res)
+	if len(res.Results) != 1 || len(res.Omitted) != 1 || res.Omitted[0] != "13800000002" {
+		t.Errorf("results %+v, omitted %v, want the first result and the second omitted", res.Results, res.Omitted)
+	}
+
+	setGlobal(t, &MaxBatchResponseBytes, 0)
+	res = batchResponse{}
+	mustRequest(t, db, http.MethodPost, "/labels", `{"mobiles":["`+strings.Join(mobiles, `","`)+`"]}`, &res)
+	if res.Truncated || len(res.Results) != 5 {
+		t.Errorf("truncated %t with %d results without the cap, want all the 5", res.Truncated, len(res.Results))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"go.uber.org/multierr"
+)
+
+// BulkSpillBytes is the size of the keys and values buffered in memory per partition by a bulk load,
+// beyond which they are spilled to a sorted SSTable, 0 to buffer all in memory.
+var BulkSpillBytes = 64 << 20
+
+// bulkLoader collects the keys of a bulk load by partition, spilling them to sorted SSTables
+// when the buffer of a partition is full, then ingests the SSTables, bypassing the op channel and the memtable.
+type bulkLoader struct {
+	s     *pebbleDB
+	parts []bulkPart
+}
+
+type bulkPart struct {
+	sync.Mutex
+	kvs   []KV
+	size  int
+	files []string // the spilled SSTables in the order of the spills
+	keys  int      // the number of the keys in the spilled SSTables
+}
+
+func (s *pebbleDB) newBulkLoader() *bulkLoader {
+	return &bulkLoader{s: s, parts: make([]bulkPart, len(s.dbs))}
+}
+
+func (b *bulkLoader) add(partition uint64, key, value []byte) error {
+	p := &b.parts[partition]
+	p.Lock()
+	defer p.Unlock()
+
+	p.kvs = append(p.kvs, KV{Key: key, Value: value})
+	p.size += len(key) + len(value)
+	if BulkSpillBytes > 0 && p.size >= BulkSpillBytes {
+		return b.spill(partition, p)
+	}
+	return nil
+}
+
+// spill writes the buffered keys of the partition to a sorted SSTable, the caller should hold the lock of the part.
+func (b *bulkLoader) spill(partition uint64, p *bulkPart) error {
+	if len(p.kvs) == 0 {
+		return nil
+	}
+
+	path, keys, err := b.s.writeSSTable(partition, p.kvs)
+	p.kvs, p.size = nil, 0
+	if err != nil {
+		return err
+	}
+	p.files = append(p.files, path)
+	p.keys += keys
+	return nil
+}
+
+// cleanup removes the SSTables left by a failed load, the ingested ones are already linked into the partitions.
+func (b *bulkLoader) cleanup() {
+	for i := range b.parts {
+		for _, f := range b.parts[i].files {
+			os.Remove(f)
+		}
+		b.parts[i].files = nil
+	}
+}
+
+// ingest ingests the collected keys into all the partitions concurrently, returns the number of the ingested keys.
+func (s *pebbleDB) ingest(b *bulkLoader) (keys int, err error) {
+	defer b.cleanup()
+	counts := make([]int, len(b.parts))
+	errs := make([]error, len(b.parts))
+
+	var wg sync.WaitGroup
+	for i := range b.parts {
+		if len(b.parts[i].kvs) == 0 && len(b.parts[i].files) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i], errs[i] = b.ingestPartition(uint64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range b.parts {
+		keys += counts[i]
+		err = multierr.Append(err, errs[i])
+	}
+	return keys, err
+}
+
+// ingestPartition spills the rest of the keys of the partition, and ingests its SSTables one by one in the order of the spills,
+// because the SSTables ingested together should not overlap, and the latter ones get the higher sequence numbers,
+// so that the latter one of the duplicate keys wins as the normal writes.
+func (b *bulkLoader) ingestPartition(partition uint64) (keys int, err error) {
+	p := &b.parts[partition]
+	p.Lock()
+	defer p.Unlock()
+
+	if err := b.spill(partition, p); err != nil {
+		return 0, err
+	}
+
+	db := b.s.dbs[partition]
+	for _, f := range p.files {
+		if err := db.Ingest([]string{f}); err != nil {
+			return 0, err
+		}
+	}
+	return p.keys, nil
+}
+
+// writeSSTable writes the keys to a sorted SSTable of the partition, returns its path and the number of the keys.
+func (s *pebbleDB) writeSSTable(partition uint64, kvs []KV) (path string, keys int, err error) {
+	// stable sort, so that the latter one of the duplicate keys wins as the normal writes.
+	sort.SliceStable(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	defer s.diskOf(partition).acquire()()
+
+	// on the same disk with the partition, so that the ingest links the file instead of copying it.
+	f, err := os.CreateTemp(filepath.Dir(partitionDir(s.path, partition)), filepath.Base(s.path)+".ingest-*.sst")
+	if err != nil {
+		return "", 0, err
+	}
+	path = f.Name()
+
+	db := s.dbs[partition]
+	w := sstable.NewWriter(f, sstable.WriterOptions{TableFormat: db.FormatMajorVersion().MaxTableFormat()})
+	for i, kv := range kvs {
+		if i+1 < len(kvs) && bytes.Equal(kv.Key, kvs[i+1].Key) {
+			continue
+		}
+		if err := w.Set(kv.Key, kv.Value); err != nil {
+			return "", 0, multierr.Combine(err, w.Close(), os.Remove(path))
+		}
+		keys++
+	}
+	if err := w.Close(); err != nil {
+		return "", 0, multierr.Append(err, os.Remove(path))
+	}
+	return path, keys, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, spill := range []int{0, 200} {
+		t.Run(fmt.Sprintf("spill-%d", spill), func(t *testing.T) { testBulkLoad(t, spill) })
+	}
+}
+
+func testBulkLoad(t *testing.T, spill int) {
+	inTempDir(t)
+	setGlobal(t, &BulkSpillBytes, spill)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 100))
+
+	if res := load(t, db, "a.txt", "vip", "bulk=y"); res.Written != 100 {
+		t.Errorf("written %d, want 100", res.Written)
+	}
+	// the keys loaded again by bulk overlap the ingested ones.
+	load(t, db, "a.txt", "big", "bulk=y")
+	load(t, db, "a.txt", "vip", "bulk=y")
+
+	if n := countLabeled(t, db, 13800000001, 100, "vip"); n != 100 {
+		t.Errorf("%d mobiles labeled vip, want 100", n)
+	}
+	if n := countLabeled(t, db, 13800000001, 100, "big"); n != 100 {
+		t.Errorf("%d mobiles labeled big, want 100", n)
+	}
+	if got := labelsOf(t, db, "13800000100"); len(got) != 2 {
+		t.Errorf("labels of 13800000100 are %v, want [big vip]", got)
+	}
+
+	// the spilled SSTables are linked into the partitions and removed.
+	if left, _ := filepath.Glob(filepath.Join(filepath.Dir(db.path), "*.sst")); len(left) > 0 {
+		t.Errorf("SSTables %v are left", left)
+	}
+}
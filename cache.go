@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ttlCache caches the results of the expensive full scans for a ttl.
+// The expired results are evicted on the writes, and the concurrent misses of the same key
+// share one computation instead of scanning again each.
+type ttlCache[V any] struct {
+	sync.Mutex
+	entries map[string]*ttlEntry[V]
+	calls   map[string]*ttlCall[V]
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expired time.Time
+}
+
+var errCallPanicked = errors.New("the shared computation panicked")
+
+// ttlCall is an in-flight computation, done is closed when it completes.
+type ttlCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// get returns the cached value of the key, or computes it by fn and caches it for ttl.
+// cached tells whether the value is from the cache, including the one shared from an in-flight computation.
+func (c *ttlCache[V]) get(key string, ttl time.Duration, fn func() (V, error)) (value V, cached bool, err error) {
+	c.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expired) {
+		c.Unlock()
+		return e.value, true, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.Unlock()
+		<-call.done
+		return call.value, true, call.err
+	}
+	if c.calls == nil {
+		c.calls = map[string]*ttlCall[V]{}
+	}
+	call := &ttlCall[V]{done: make(chan struct{})}
+	c.calls[key] = call
+	c.Unlock()
+
+	defer func() {
+		c.Lock()
+		delete(c.calls, key)
+		if call.err == nil {
+			c.evictExpired()
+			if c.entries == nil {
+				c.entries = map[string]*ttlEntry[V]{}
+			}
+			c.entries[key] = &ttlEntry[V]{value: call.value, expired: time.Now().Add(ttl)}
+		}
+		c.Unlock()
+		close(call.done)
+	}()
+
+	// the waiters get errCallPanicked if fn panics, the panic itself goes on to the caller.
+	call.err = errCallPanicked
+	call.value, call.err = fn()
+	return call.value, false, call.err
+}
+
+// evictExpired removes the expired entries, the caller should hold the lock.
+func (c *ttlCache[V]) evictExpired() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if !now.Before(e.expired) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// size returns the number of the cached entries, including the expired ones not evicted yet.
+func (c *ttlCache[V]) size() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.entries)
+}
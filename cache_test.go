@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheEvictsExpired(t *testing.T) {
+	var c ttlCache[int]
+	for _, key := range []string{"a", "b"} {
+		if _, _, err := c.get(key, time.Nanosecond, func() (int, error) { return 1, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	// the expired a and b are evicted on the write of c.
+	if _, _, err := c.get("c", time.Minute, func() (int, error) { return 1, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.size(); n != 1 {
+		t.Errorf("cache size %d, want 1 after the expired are evicted", n)
+	}
+	if _, cached, _ := c.get("c", time.Minute, func() (int, error) { return 2, nil }); !cached {
+		t.Error("c should be cached")
+	}
+}
+
+func TestTTLCacheSharesInflight(t *testing.T) {
+	var c ttlCache[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	values := make([]int, 8)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _, _ = c.get("k", time.Minute, func() (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("computed %d times, want once for the concurrent misses", n)
+	}
+	for i, v := range values {
+		if v != 42 {
+			t.Errorf("value %d is %d, want 42", i, v)
+		}
+	}
+}
+
+func TestTTLCachePanic(t *testing.T) {
+	var c ttlCache[int]
+	started, release := make(chan struct{}), make(chan struct{})
+	panicked := make(chan any)
+	go func() {
+		defer func() { panicked <- recover() }()
+		c.get("k", time.Minute, func() (int, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	// the waiter of the in-flight call gets an error instead of blocking.
+	waited := make(chan error)
+	go func() {
+		_, _, err := c.get("k", time.Minute, func() (int, error) { return 1, nil })
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if p := <-panicked; p != "boom" {
+		t.Errorf("recovered %v, want the panic to reach the caller", p)
+	}
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("the waiter of the panicked call should get an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiter of the panicked call blocks")
+	}
+
+	// the key is computed again after the panic.
+	done := make(chan int)
+	go func() {
+		v, _, _ := c.get("k", time.Minute, func() (int, error) { return 2, nil })
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		if v != 2 {
+			t.Errorf("value %d after the panic, want 2 computed again", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the get after the panic blocks")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// CollisionPolicy is the policy when a load writes a key which was written from a different mobile string,
+// e.g. 013800138000 and 13800138000 are both encoded as the uint64 13800138000, or two inputs mapped
+// to the same mobile by a transform.
+//
+// To detect the collisions, the loads store a checksum of the mobile string (after the transforms) in the values,
+// and compare it with the checksum of the existing key before writing. The keys written without a checksum,
+// by the bulk loads, or still queued in the op channel are not detected.
+type CollisionPolicy string
+
+const (
+	// CollisionOff disables the checksums and the detection, the existing keys are silently overwritten.
+	CollisionOff CollisionPolicy = ""
+	// CollisionOverwrite overwrites the existing key, counting the collision in the load response.
+	CollisionOverwrite CollisionPolicy = "overwrite"
+	// CollisionReject keeps the existing key, and counts the line as rejected.
+	CollisionReject CollisionPolicy = "reject"
+	// CollisionLog overwrites the existing key, and logs the collision as a warning.
+	CollisionLog CollisionPolicy = "log"
+)
+
+// Collisions is the collision policy of the loads.
+var Collisions = CollisionOff
+
+// ParseCollisionPolicy parses the collision policy of off/overwrite/reject/log.
+func ParseCollisionPolicy(v string) (CollisionPolicy, error) {
+	switch p := CollisionPolicy(v); p {
+	case CollisionOverwrite, CollisionReject, CollisionLog:
+		return p, nil
+	case "off", CollisionOff:
+		return CollisionOff, nil
+	default:
+		return CollisionOff, fmt.Errorf("bad collision policy %s, should be off/overwrite/reject/log", v)
+	}
+}
+
+// mobileSum is the checksum of the mobile string stored in the value.
+func mobileSum(mobile string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(mobile))), 36)
+}
+
+// collides tells whether the existing key of mobile+label was written from a mobile string other than the one of sum.
+func (s *pebbleDB) collides(mobile, label []byte, sum string) (bool, error) {
+	key := append(append(make([]byte, 0, len(mobile)+len(label)), mobile...), label...)
+	v, closer, err := s.dbs[s.Partition(mobile)].Get(key)
+	if err == pebble.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	existing := DecodeLabelValue(v).Sum
+	if err := closer.Close(); err != nil {
+		return false, err
+	}
+	return existing != "" && existing != sum, nil
+}
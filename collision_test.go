@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// collisionLoadResult is the load response with the collision count.
+type collisionLoadResult struct {
+	loadResult
+	Collisions *uint64 `json:"collisions"`
+}
+
+// sumOf returns the mobile checksum stored with the label of the mobile.
+func sumOf(t *testing.T, db *pebbleDB, mobile, label string) string {
+	t.Helper()
+	m, _ := mobile2bytes(mobile)
+	v, closer, err := db.dbs[db.Partition(m)].Get(append(m, label...))
+	if err != nil {
+		t.Fatalf("label %s of %s: %v", label, mobile, err)
+	}
+	defer closer.Close()
+	return DecodeLabelValue(v).Sum
+}
+
+func TestCollisionPolicy(t *testing.T) {
+	// the leading zero is lost in the uint64 key, so the two mobile strings collide.
+	original, other := mobileSum("13800138000"), mobileSum("013800138000")
+	for _, c := range []struct {
+		policy            CollisionPolicy
+		collisions        uint64
+		written, rejected uint64
+		sum               string
+		logged            bool
+	}{
+		{CollisionOverwrite, 1, 1, 0, other, false},
+		{CollisionReject, 1, 0, 1, original, false},
+		{CollisionLog, 1, 1, 0, other, true},
+	} {
+		t.Run(string(c.policy), func(t *testing.T) {
+			inTempDir(t)
+			setGlobal(t, &Collisions, c.policy)
+			db := openTestDB(t, 4)
+			writeTestFile(t, "a.txt", "13800138000\n")
+			writeTestFile(t, "b.txt", "013800138000\n")
+			load(t, db, "a.txt", "vip", "")
+
+			// the reload of the same mobile string is not a collision.
+			var res collisionLoadResult
+			mustRequest(t, db, http.MethodPost, "/load/a.txt/vip", "", &res)
+			db.Barrier()
+			if res.Collisions == nil || *res.Collisions != 0 {
+				t.Errorf("collisions %v of the reload, want 0", res.Collisions)
+			}
+
+			buf := captureLog(t)
+			res = collisionLoadResult{}
+			mustRequest(t, db, http.MethodPost, "/load/b.txt/vip", "", &res)
+			db.Barrier()
+			if res.Collisions == nil || *res.Collisions != c.collisions || res.Written != c.written || res.Rejected != c.rejected {
+				t.Errorf("load result %+v of %v collisions, want %d collisions, %d written and %d rejected",
+					res, res.Collisions, c.collisions, c.written, c.rejected)
+			}
+			if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "policy "+string(c.policy)) {
+				t.Errorf("warnings %q, want the collision", res.Warnings)
+			}
+			if sum := sumOf(t, db, "13800138000", "vip"); sum != c.sum {
+				t.Errorf("sum %s of the key, want %s", sum, c.sum)
+			}
+			if logged := strings.Contains(buf.String(), "collides with an existing key"); logged != c.logged {
+				t.Errorf("collision logged %t, want %t", logged, c.logged)
+			}
+		})
+	}
+}
+
+func TestCollisionOff(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800138000\n")
+	writeTestFile(t, "b.txt", "013800138000\n")
+	load(t, db, "a.txt", "vip", "")
+
+	var res collisionLoadResult
+	mustRequest(t, db, http.MethodPost, "/load/b.txt/vip", "", &res)
+	db.Barrier()
+	if res.Collisions != nil || res.Written != 1 {
+		t.Errorf("load result %+v, want the key silently overwritten", res)
+	}
+	if sum := sumOf(t, db, "13800138000", "vip"); sum != "" {
+		t.Errorf("sum %s stored without the detection", sum)
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	for v, want := range map[string]CollisionPolicy{"": CollisionOff, "off": CollisionOff, "reject": CollisionReject, "log": CollisionLog} {
+		if p, err := ParseCollisionPolicy(v); err != nil || p != want {
+			t.Errorf("parse %q = %q, %v, want %q", v, p, err, want)
+		}
+	}
+	if _, err := ParseCollisionPolicy("ignore"); err == nil {
+		t.Error("parse of the unknown policy should fail")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// mobileLen is the length of the mobile bytes at the head of every key.
+const mobileLen = 8
+
+// CooccurTTL is the time to live of the cached co-occurrence results.
+var CooccurTTL = 5 * time.Minute
+
+// LabelCount is a label with its count.
+type LabelCount struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type cooccurResult struct {
+	counts  []LabelCount
+	mobiles int
+}
+
+// Cooccur lists the labels co-occurring with the label.
+// The label is registered as :mobile, because httprouter requires the same wildcard name at the same segment.
+func (s *pebbleDB) Cooccur(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	start := time.Now()
+	label := NormalizeLabel(p.ByName("mobile"))
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	top := 10
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		top = n
+	}
+
+	result, cached, err := s.cooccur(ds, label)
+	if err != nil {
+		return err
+	}
+
+	counts := result.counts
+	if top > 0 && len(counts) > top {
+		counts = counts[:top]
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "cached": cached, "mobiles": result.mobiles, "labels": counts})
+}
+
+func (s *pebbleDB) cooccur(ds dataset, label string) (result *cooccurResult, cached bool, err error) {
+	return s.cooccurs.get(string(ds.prefix)+label, CooccurTTL, func() (*cooccurResult, error) {
+		return s.tallyCooccur(ds, []byte(label))
+	})
+}
+
+// tallyCooccur scans all the partitions concurrently, for every mobile with the label,
+// tallies its other labels.
+func (s *pebbleDB) tallyCooccur(ds dataset, label []byte) (*cooccurResult, error) {
+	tallies := make([]map[string]int, len(s.dbs))
+	mobiles := make([]int, len(s.dbs))
+	errs := make([]error, len(s.dbs))
+
+	var wg sync.WaitGroup
+	for i := range s.dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			tally := map[string]int{}
+			errs[i] = s.iterateMobiles(i, ds, func(_ []byte, labels [][]byte) {
+				found := false
+				for _, l := range labels {
+					if bytes.Equal(l, label) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return
+				}
+
+				mobiles[i]++
+				for _, l := range labels {
+					if !bytes.Equal(l, label) {
+						tally[string(l)]++
+					}
+				}
+			})
+			tallies[i] = tally
+		}(i)
+	}
+	wg.Wait()
+
+	result := &cooccurResult{}
+	total := map[string]int{}
+	for i, tally := range tallies {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result.mobiles += mobiles[i]
+		for l, n := range tally {
+			total[l] += n
+		}
+	}
+
+	for l, n := range total {
+		result.counts = append(result.counts, LabelCount{Label: l, Count: n})
+	}
+	sort.Slice(result.counts, func(i, j int) bool {
+		if a, b := result.counts[i], result.counts[j]; a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return result.counts[i].Label < result.counts[j].Label
+	})
+
+	return result, nil
+}
+
+// iterateMobiles iterates all the keys of the dataset in the partition, grouping the labels by mobile.
+// The keys are sorted, so all the labels of one mobile are adjacent.
+func (s *pebbleDB) iterateMobiles(partition int, ds dataset, fn func(mobile []byte, labels [][]byte)) error {
+	var mobile []byte
+	var labels [][]byte
+
+	if err := s.scanPartitionRange(partition, ds.iterOptions(), func(batch []KV) error {
+		for _, kv := range batch {
+			m, label, ok := ds.split(kv.Key)
+			if !ok {
+				continue
+			}
+
+			if !bytes.Equal(m, mobile) {
+				if len(labels) > 0 {
+					fn(mobile, labels)
+				}
+				mobile = append(mobile[:0], m...)
+				labels = labels[:0]
+			}
+			labels = append(labels, label)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(labels) > 0 {
+		fn(mobile, labels)
+	}
+	return nil
+}
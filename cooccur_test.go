@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCooccur(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "vip.txt", "13800000001\n13800000002\n13800000003\n")
+	writeTestFile(t, "big.txt", "13800000001\n13800000002\n13800000009\n")
+	writeTestFile(t, "new.txt", "13800000003\n13800000009\n")
+	load(t, db, "vip.txt", "vip", "")
+	load(t, db, "big.txt", "big", "")
+	load(t, db, "new.txt", "new", "")
+
+	var res struct {
+		Cached  bool         `json:"cached"`
+		Mobiles int          `json:"mobiles"`
+		Labels  []LabelCount `json:"labels"`
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/vip/cooccur", "", &res)
+	want := []LabelCount{{Label: "big", Count: 2}, {Label: "new", Count: 1}}
+	if res.Cached || res.Mobiles != 3 || len(res.Labels) != len(want) {
+		t.Fatalf("cooccur of vip is %+v, want 3 mobiles with %+v", res, want)
+	}
+	for i := range want {
+		if res.Labels[i] != want[i] {
+			t.Errorf("cooccur %d is %+v, want %+v", i, res.Labels[i], want[i])
+		}
+	}
+
+	mustRequest(t, db, http.MethodGet, "/labels/vip/cooccur?top=1", "", &res)
+	if !res.Cached || len(res.Labels) != 1 || res.Labels[0] != want[0] {
+		t.Errorf("cooccur of vip top 1 is %+v, want the cached %+v", res, want[:1])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CountMobiles counts the distinct mobiles in the store, in mode exact (default) or approx.
+// It is registered as /mobiles/:label, because httprouter requires the same wildcard name
+// at the same segment as /mobiles/:label/sample, only /mobiles/count is served.
+func (s *pebbleDB) CountMobiles(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	if p.ByName("label") != "count" {
+		return &StatusError{Code: http.StatusNotFound, Err: fmt.Errorf("not found")}
+	}
+
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	mode := r.URL.Query().Get("mode")
+	var count uint64
+	switch mode {
+	case "", "exact":
+		mode = "exact"
+		if count, err = s.CountDistinctMobiles(ds); err != nil {
+			return err
+		}
+	case "approx":
+		// the HyperLogLog is of the keys of all the datasets, it can not count a single dataset.
+		if r.URL.Query().Has("dataset") {
+			return fmt.Errorf("mode approx counts the mobiles of all the datasets, use mode exact for dataset %q", r.URL.Query().Get("dataset"))
+		}
+		count = s.hll.Count()
+	default:
+		return fmt.Errorf("unknown mode %s, should be exact or approx", mode)
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "mode": mode, "count": count})
+}
+
+// CountDistinctMobiles counts the distinct mobiles of the dataset exactly by iterating all the keys and collapsing on the mobile prefix.
+// A mobile lives only in one partition, so the counts of the partitions are summed.
+func (s *pebbleDB) CountDistinctMobiles(ds dataset) (count uint64, err error) {
+	for i := range s.dbs {
+		var last []byte
+		if err := s.scanPartitionRange(i, ds.iterOptions(), func(batch []KV) error {
+			for _, kv := range batch {
+				mobile, _, ok := ds.split(kv.Key)
+				if !ok || bytes.Equal(mobile, last) {
+					continue
+				}
+				last = mobile
+				count++
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
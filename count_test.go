@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+type countResult struct {
+	Mode  string `json:"mode"`
+	Count uint64 `json:"count"`
+}
+
+func TestCountMobiles(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	// 1500 distinct mobiles, 500 of which are with the 3 labels.
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 1000))
+	writeTestFile(t, "b.txt", mobilesFile(13800000500, 1000))
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "b.txt", "big", "")
+	load(t, db, "a.txt", "new", "")
+
+	var res countResult
+	mustRequest(t, db, http.MethodGet, "/mobiles/count", "", &res)
+	if res.Mode != "exact" || res.Count != 1500 {
+		t.Errorf("count %+v, want exact 1500", res)
+	}
+
+	// the HyperLogLog is persisted in the meta.
+	db = reopenTestDB(t, db)
+	mustRequest(t, db, http.MethodGet, "/mobiles/count?mode=approx", "", &res)
+	if res.Mode != "approx" || math.Abs(float64(res.Count)-1500) > 1500*0.05 {
+		t.Errorf("count %+v, want approx 1500 within 5%%", res)
+	}
+
+	if code, _ := request(t, db, http.MethodGet, "/mobiles/count?mode=bad", "", nil); code != http.StatusBadRequest {
+		t.Errorf("status %d of the bad mode, want 400", code)
+	}
+}
+
+func TestCountApproxOfDataset(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &Datasets, true)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 10))
+	load(t, db, "a.txt", "vip", "dataset=d1")
+
+	var res countResult
+	mustRequest(t, db, http.MethodGet, "/mobiles/count?dataset=d1", "", &res)
+	if res.Count != 10 {
+		t.Errorf("count %+v of dataset d1, want exact 10", res)
+	}
+	// the HyperLogLog counts all the datasets, so it does not answer for a dataset.
+	if code, _ := request(t, db, http.MethodGet, "/mobiles/count?mode=approx&dataset=d1", "", nil); code != http.StatusBadRequest {
+		t.Errorf("status %d of approx with the dataset, want 400", code)
+	}
+}
+
+func TestHyperLogLogMarshal(t *testing.T) {
+	var h HyperLogLog
+	for i := 0; i < 10000; i++ {
+		mobile, _ := mobile2bytes(fmt.Sprint(13800000000 + i))
+		h.Add(mobile)
+		h.Add(mobile)
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h2 HyperLogLog
+	if err := h2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if h.Count() != h2.Count() || math.Abs(float64(h.Count())-10000) > 10000*0.05 {
+		t.Errorf("counts %d and %d of the unmarshaled, want the same about 10000", h.Count(), h2.Count())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Datasets prefixes all the keys with a dataset name (before the mobile), so that many small datasets share
+// one set of partition dbs, selected by the query param dataset (empty for the default dataset).
+// It is persisted in the meta at creation, because the keys are laid out differently.
+// The key is {len(dataset) byte}{dataset}{mobile}{label}.
+var Datasets bool
+
+// maxDatasetLen is the max length of a dataset name, which is prefixed by a byte of its length.
+const maxDatasetLen = 255
+
+// dataset is the key space of a dataset.
+type dataset struct {
+	prefix []byte // nil when Datasets is off
+}
+
+// datasetOf returns the dataset of the request by the query param dataset.
+func datasetOf(r *http.Request) (dataset, error) {
+	name := r.URL.Query().Get("dataset")
+	if !Datasets {
+		if name != "" {
+			return dataset{}, fmt.Errorf("dataset %q is not supported, start with -datasets to enable datasets", name)
+		}
+		return dataset{}, nil
+	}
+	if len(name) > maxDatasetLen {
+		return dataset{}, fmt.Errorf("dataset name exceeds %d bytes", maxDatasetLen)
+	}
+
+	return dataset{prefix: append([]byte{byte(len(name))}, name...)}, nil
+}
+
+// key returns the mobile key, the mobile with the dataset prefix, which prefixes the keys of its labels.
+func (d dataset) key(mobile []byte) []byte {
+	if d.prefix == nil {
+		return mobile
+	}
+	return append(append(make([]byte, 0, len(d.prefix)+len(mobile)), d.prefix...), mobile...)
+}
+
+// split splits the key of the dataset to the mobile and the label.
+func (d dataset) split(key []byte) (mobile, label []byte, ok bool) {
+	n := len(d.prefix)
+	if len(key) < n+mobileLen {
+		return nil, nil, false
+	}
+	return key[n : n+mobileLen], key[n+mobileLen:], true
+}
+
+// iterOptions returns the iterator options of the key range of the dataset, nil for all the keys.
+func (d dataset) iterOptions() *pebble.IterOptions {
+	if d.prefix == nil {
+		return nil
+	}
+	return prefixIterOptions(d.prefix)
+}
+
+// mobileKeyLen returns the length of the mobile key at the head of the key, -1 for a bad key.
+func mobileKeyLen(key []byte) int {
+	return mobileKeyLenOf(key, Datasets)
+}
+
+// mobileKeyLenOf is mobileKeyLen of the keys laid out with or without datasets, e.g. of another db.
+func mobileKeyLenOf(key []byte, datasets bool) int {
+	n := mobileLen
+	if datasets {
+		if len(key) == 0 {
+			return -1
+		}
+		n += 1 + int(key[0])
+	}
+	if len(key) < n {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDatasets(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &Datasets, true)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 5))
+
+	load(t, db, "a.txt", "vip", "dataset=a")
+	load(t, db, "a.txt", "big", "dataset=b")
+	for query, want := range map[string]string{"?dataset=a": "vip", "?dataset=b": "big", "": ""} {
+		var res struct {
+			Labels []string `json:"labels"`
+		}
+		mustRequest(t, db, http.MethodGet, "/labels/13800000001"+query, "", &res)
+		if want == "" && len(res.Labels) != 0 || want != "" && (len(res.Labels) != 1 || res.Labels[0] != want) {
+			t.Errorf("labels %v of dataset %q, want only %q", res.Labels, query, want)
+		}
+	}
+
+	for query, want := range map[string]uint64{"?dataset=a": 5, "?dataset=b": 5, "?dataset=c": 0, "": 0} {
+		var count struct {
+			Count uint64 `json:"count"`
+		}
+		mustRequest(t, db, http.MethodGet, "/mobiles/count"+query, "", &count)
+		if count.Count != want {
+			t.Errorf("%d mobiles of dataset %q, want %d", count.Count, query, want)
+		}
+	}
+	if lines, _ := export(t, db, "dataset=b"); len(exportRecords(lines)) != 5 {
+		t.Errorf("%d records exported of dataset b, want 5", len(exportRecords(lines)))
+	}
+
+	// the purge of a dataset keeps the mobile in the others.
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000001?dataset=a", "", nil)
+	db.Barrier()
+	var res struct {
+		Labels []string `json:"labels"`
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001?dataset=b", "", &res)
+	if len(res.Labels) != 1 {
+		t.Errorf("labels %v of dataset b after purging dataset a, want [big]", res.Labels)
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001?dataset=a", "", &res)
+	if len(res.Labels) != 0 {
+		t.Errorf("labels %v of the purged dataset a, want none", res.Labels)
+	}
+
+	// the datasets are persisted in the meta and adopted on reopen.
+	Datasets = false
+	db = reopenTestDB(t, db)
+	if !Datasets {
+		t.Fatal("datasets are off after reopen, want the persisted on")
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000002?dataset=a", "", &res)
+	if len(res.Labels) != 1 || res.Labels[0] != "vip" {
+		t.Errorf("labels %v of dataset a after reopen, want [vip]", res.Labels)
+	}
+}
+
+func TestDatasetsOff(t *testing.T) {
+	db := openTestDB(t, 4)
+	if code, _ := request(t, db, http.MethodGet, "/labels/13800000001?dataset=a", "", nil); code != http.StatusBadRequest {
+		t.Errorf("query of a dataset without datasets: status %d, want 400", code)
+	}
+}
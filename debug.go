@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DebugChunks responds the byte ranges [start, end) the workers would scan for the file, without scanning it.
+// The file scanned sequentially by the loads, see scanSequentially, is a single chunk of a single reader.
+func DebugChunks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	file := r.URL.Query().Get("file")
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	workers, err := parseWorkers(r.URL.Query().Get("workers"))
+	if err != nil {
+		return err
+	}
+	// the workers are also limited by the file size in chunkRanges.
+	chunks := chunkRanges(int(stat.Size()), scanWorkers(workers))
+	body := H{"file": file, "size": stat.Size(), "workers": len(chunks), "chunks": chunks}
+	if scanSequentially(file, r.URL.Query().Get("format") == "ndjson") {
+		body["workers"] = 1
+		body["chunks"] = []Chunk{{Start: 0, End: int(stat.Size())}}
+	}
+	return jsonResponse(w, body)
+}
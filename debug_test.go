@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkRangesTileTheFile(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 100, 1023, 1 << 20} {
+		for _, workers := range []int{1, 2, 3, 7, 64, 2000} {
+			chunks := chunkRanges(size, workers)
+			end := 0
+			for i, c := range chunks {
+				if c.Start != end || c.End < c.Start {
+					t.Errorf("size %d by %d workers: chunk %d %+v, want starting at %d", size, workers, i, c, end)
+				}
+				end = c.End
+			}
+			if end != size || len(chunks) > workers {
+				t.Errorf("size %d by %d workers: %d chunks end at %d, want tiling the file", size, workers, len(chunks), end)
+			}
+		}
+	}
+}
+
+func TestDebugChunks(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &ScanWorkers, 4)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", strings.Repeat("13800000000\n", 100))
+
+	var res struct {
+		Size    int     `json:"size"`
+		Workers int     `json:"workers"`
+		Chunks  []Chunk `json:"chunks"`
+	}
+	mustRequest(t, db, http.MethodGet, "/debug/chunks?file=a.txt&workers=16", "", &res)
+	if res.Size != 1200 || res.Workers != 16 || len(res.Chunks) != 16 {
+		t.Fatalf("chunks %+v, want 16 of 1200 bytes", res)
+	}
+	if fmt.Sprint(res.Chunks) != fmt.Sprint(chunkRanges(1200, 16)) {
+		t.Errorf("chunks %v, want the ones scanFile assigns %v", res.Chunks, chunkRanges(1200, 16))
+	}
+
+	if code, _ := request(t, db, http.MethodGet, "/debug/chunks?file=a.txt&workers=17", "", nil); code != http.StatusBadRequest {
+		t.Errorf("status %d of the workers beyond the limit, want 400", code)
+	}
+
+	// the files the loads scan sequentially are a single chunk of a single reader.
+	writeTestFile(t, "a.tar", strings.Repeat("x", 1200))
+	for _, target := range []string{"/debug/chunks?file=a.tar&workers=16", "/debug/chunks?file=a.txt&workers=16&format=ndjson"} {
+		mustRequest(t, db, http.MethodGet, target, "", &res)
+		if res.Workers != 1 || len(res.Chunks) != 1 || res.Chunks[0] != (Chunk{Start: 0, End: 1200}) {
+			t.Errorf("%s: chunks %+v, want a single reader", target, res)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"sync/atomic"
+)
+
+// DiskConcurrency is the max number of the concurrent full scans and bulk ingests per disk (base dir), 0 for unlimited.
+var DiskConcurrency = 0
+
+// disk is a base dir of the partitions, assumed to be a physical disk, see PartitionBaseDirs.
+type disk struct {
+	Dir        string `json:"dir"`
+	Partitions int    `json:"partitions"`
+
+	slots  chan struct{}
+	ios    atomic.Uint64
+	active atomic.Int64
+}
+
+// newDisks creates the disks of the partitions, partition i is on disk i%len(disks) as partitionDir places it.
+func newDisks(path string, partitions uint64) []*disk {
+	dirs := PartitionBaseDirs
+	if len(dirs) == 0 {
+		dirs = []string{filepath.Dir(path)}
+	}
+
+	disks := make([]*disk, len(dirs))
+	for i, dir := range dirs {
+		disks[i] = &disk{Dir: dir}
+		if DiskConcurrency > 0 {
+			disks[i].slots = make(chan struct{}, DiskConcurrency)
+		}
+	}
+	for i := uint64(0); i < partitions; i++ {
+		disks[i%uint64(len(disks))].Partitions++
+	}
+	return disks
+}
+
+func (s *pebbleDB) diskOf(partition uint64) *disk {
+	return s.disks[partition%uint64(len(s.disks))]
+}
+
+// acquire blocks until the disk has a free I/O slot, the returned release func should be called after the I/O.
+func (d *disk) acquire() (release func()) {
+	if d.slots != nil {
+		d.slots <- struct{}{}
+	}
+	d.ios.Add(1)
+	d.active.Add(1)
+	return func() {
+		d.active.Add(-1)
+		if d.slots != nil {
+			<-d.slots
+		}
+	}
+}
+
+func (d *disk) stats() H {
+	return H{"dir": d.Dir, "partitions": d.Partitions, "ios": d.ios.Load(), "active": d.active.Load()}
+}
+
+// affinityWriters rounds down the number of the shared writers to a multiple of the disks,
+// so that each writer (op channel) serves the partitions on the same disk only.
+func affinityWriters(writers uint64, disks int) uint64 {
+	if n := uint64(disks); n > 1 && writers > n {
+		writers -= writers % n
+	}
+	return writers
+}
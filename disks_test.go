@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskIO(t *testing.T) {
+	inTempDir(t)
+	dirs := []string{t.TempDir(), t.TempDir()}
+	setGlobal(t, &PartitionBaseDirs, dirs)
+	setGlobal(t, &MaxWriters, 3)
+	db := openTestDBAt(t, filepath.Join(t.TempDir(), "db"), 8)
+
+	// the writers are rounded down to 2, each serves the partitions on one disk only.
+	if len(db.dbc) != 2 {
+		t.Fatalf("%d writers, want 2 for the 2 disks", len(db.dbc))
+	}
+	serving := map[chan op]int{}
+	for p := uint64(0); p < 8; p++ {
+		disk := int(p % 2)
+		if d, ok := serving[db.opc(p)]; ok && d != disk {
+			t.Errorf("the writer of partition %d serves the disks %d and %d", p, d, disk)
+		}
+		serving[db.opc(p)] = disk
+	}
+
+	// a full scan reads every partition on its disk once.
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 20))
+	load(t, db, "a.txt", "vip", "")
+	export(t, db, "")
+	var stats struct {
+		Disks []struct {
+			Dir        string `json:"dir"`
+			Partitions int    `json:"partitions"`
+			IOs        uint64 `json:"ios"`
+		} `json:"disks"`
+	}
+	mustRequest(t, db, http.MethodGet, "/stats", "", &stats)
+	if len(stats.Disks) != 2 {
+		t.Fatalf("stats of %d disks, want 2", len(stats.Disks))
+	}
+	for i, d := range stats.Disks {
+		if d.Dir != dirs[i] || d.Partitions != 4 || d.IOs != 4 {
+			t.Errorf("disk %d: %+v, want 4 partitions of %s scanned once each", i, d, dirs[i])
+		}
+	}
+}
+
+func TestDiskConcurrency(t *testing.T) {
+	setGlobal(t, &DiskConcurrency, 2)
+	disks := newDisks("db", 4)
+
+	// the concurrent I/O of a disk are capped, regardless of the other disk.
+	var active, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer disks[0].acquire()()
+			n := active.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			active.Add(-1)
+		}()
+	}
+	wg.Wait()
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak %d concurrent I/O of the disk, want at most 2", p)
+	}
+	if n := disks[0].ios.Load(); n != 10 {
+		t.Errorf("%d I/O counted, want 10", n)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ExportCheckpointEvery is the number of the exported records between the checkpoint markers.
+var ExportCheckpointEvery = 100000
+
+// ExportRecord is a label of a mobile in the export stream.
+type ExportRecord struct {
+	Mobile string `json:"mobile"`
+	Label  string `json:"label"`
+}
+
+// exportCursor is the export progress of the partitions, the last exported key (in hex) of each partition,
+// empty for not started yet, or "-" for done.
+type exportCursor struct {
+	Last []string `json:"last"`
+}
+
+const exportDone = "-"
+
+func (c exportCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeExportCursor(v string, partitions int) (c exportCursor, err error) {
+	if v == "" {
+		return exportCursor{Last: make([]string, partitions)}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("bad resume cursor: %w", err)
+	}
+	if len(c.Last) != partitions {
+		return c, fmt.Errorf("resume cursor of %d partitions, but the db has %d", len(c.Last), partitions)
+	}
+	for i, last := range c.Last {
+		if last == "" || last == exportDone {
+			continue
+		}
+		if _, err := hex.DecodeString(last); err != nil {
+			return c, fmt.Errorf("bad resume cursor of partition %d: %w", i, err)
+		}
+	}
+	return c, nil
+}
+
+// exportBatch is a batch of the keys of a partition, or the end of the partition with a nil batch.
+type exportBatch struct {
+	partition int
+	batch     []KV
+	err       error
+}
+
+// Export streams all the labels of the dataset as newline delimited JSON records, scanning the partitions in parallel.
+// A checkpoint marker {"checkpoint":"{cursor}"} is emitted every ExportCheckpointEvery records, and at the end of each partition.
+// The records before a checkpoint are all covered by its cursor, so an interrupted export is resumed by
+// truncating the output after the last checkpoint, and requesting again with resume={cursor}.
+// The memory is bounded by ScanBatchSize keys per partition.
+func (s *pebbleDB) Export(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	cursor, err := decodeExportCursor(r.URL.Query().Get("resume"), len(s.dbs))
+	if err != nil {
+		return err
+	}
+	every := ExportCheckpointEvery
+	if n, err := strconv.Atoi(r.URL.Query().Get("checkpoint")); err == nil && n > 0 {
+		every = n
+	}
+
+	// the cursor is validated before any scanner starts, the handler must not return before the drain is deferred.
+	batches := make(chan exportBatch, len(s.dbs))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, last := range cursor.Last {
+		if last == exportDone {
+			continue
+		}
+		opts := &pebble.IterOptions{}
+		if o := ds.iterOptions(); o != nil {
+			*opts = *o
+		}
+		if last != "" {
+			key, _ := hex.DecodeString(last)
+			// starts right after the last exported key.
+			opts.LowerBound = append(key, 0)
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.scanPartitionRange(i, opts, func(batch []KV) error {
+				select {
+				case batches <- exportBatch{partition: i, batch: batch}:
+					return nil
+				case <-stop:
+					return errClosing
+				}
+			})
+			select {
+			case batches <- exportBatch{partition: i, err: err}:
+			case <-stop:
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(batches)
+	}()
+	defer func() {
+		close(stop)
+		for range batches { // drain to let the scanners exit
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	checkpoint := func() error {
+		if err := enc.Encode(H{"checkpoint": cursor.encode()}); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	records, sinceCheckpoint := 0, 0
+	for b := range batches {
+		if b.batch == nil {
+			if b.err != nil {
+				// the response is already streaming, report the error as the last record.
+				logAt(LevelError, "export partition %d failed: %v", b.partition, b.err)
+				return enc.Encode(H{"error": fmt.Sprintf("export partition %d: %v", b.partition, b.err)})
+			}
+			cursor.Last[b.partition] = exportDone
+			if err := checkpoint(); err != nil {
+				return nil // the client is gone
+			}
+			sinceCheckpoint = 0
+			continue
+		}
+
+		for _, kv := range b.batch {
+			mobile, label, ok := ds.split(kv.Key)
+			if !ok {
+				continue
+			}
+			rec := ExportRecord{Mobile: bytes2mobile(mobile), Label: string(label)}
+			if err := enc.Encode(rec); err != nil {
+				return nil // the client is gone
+			}
+			records++
+		}
+		cursor.Last[b.partition] = hex.EncodeToString(b.batch[len(b.batch)-1].Key)
+		if sinceCheckpoint += len(b.batch); sinceCheckpoint >= every {
+			if err := checkpoint(); err != nil {
+				return nil
+			}
+			sinceCheckpoint = 0
+		}
+	}
+
+	logAt(LevelInfo, "export records: %d, cost %s", records, time.Since(start))
+	return enc.Encode(H{"done": true, "records": records})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// exportLine is a line of the export stream, a record, a checkpoint marker or the end.
+type exportLine struct {
+	ExportRecord
+	Checkpoint string `json:"checkpoint"`
+	Done       bool   `json:"done"`
+	Error      string `json:"error"`
+}
+
+// export requests the export by the query, returns the lines of the stream and whether the response is flushed.
+func export(t *testing.T, db *pebbleDB, query string) (lines []exportLine, flushed bool) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter(db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export %s: status %d, %s", query, w.Code, w.Body)
+	}
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var l exportLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatalf("bad export line %q: %v", sc.Text(), err)
+		}
+		if l.Error != "" {
+			t.Fatalf("export %s: %s", query, l.Error)
+		}
+		lines = append(lines, l)
+	}
+	return lines, w.Flushed
+}
+
+// exportRecords returns the sorted records of the lines.
+func exportRecords(lines []exportLine) (records []string) {
+	for _, l := range lines {
+		if l.Mobile != "" {
+			records = append(records, l.Mobile+"/"+l.Label)
+		}
+	}
+	sort.Strings(records)
+	return records
+}
+
+func TestExportResume(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &ScanBatchSize, 4)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 30))
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "a.txt", "big", "")
+
+	full, _ := export(t, db, "")
+	want := exportRecords(full)
+	if len(want) != 60 || !full[len(full)-1].Done {
+		t.Fatalf("full export of %d records, want all the 60 and the end", len(want))
+	}
+
+	// the export interrupted after a checkpoint in the middle keeps the records up to the checkpoint.
+	lines, _ := export(t, db, "checkpoint=5")
+	var checkpoints []int
+	for i, l := range lines {
+		if l.Checkpoint != "" {
+			checkpoints = append(checkpoints, i)
+		}
+	}
+	if len(checkpoints) < 3 {
+		t.Fatalf("%d checkpoints, want more by every 5 records", len(checkpoints))
+	}
+	cut := checkpoints[len(checkpoints)/2]
+	kept := lines[:cut+1]
+
+	resumed, _ := export(t, db, "resume="+lines[cut].Checkpoint)
+	got := exportRecords(append(kept, resumed...))
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("the interrupted and the resumed exports have %d records, want the %d of the full export", len(got), len(want))
+	}
+
+	if rest := exportRecords(resumed); len(rest) == 0 || len(rest) >= len(want) {
+		t.Errorf("%d records resumed, want the rest only", len(rest))
+	}
+	// the cursor of the finished export, the last checkpoint before the end, resumes nothing.
+	if done, _ := export(t, db, "resume="+resumed[len(resumed)-2].Checkpoint); len(exportRecords(done)) != 0 {
+		t.Errorf("resume of the last cursor exports %d records, want none", len(exportRecords(done)))
+	}
+}
+
+func TestExportBadCursor(t *testing.T) {
+	db := openTestDB(t, 4)
+	for _, resume := range []string{
+		"!",
+		exportCursor{Last: []string{"", ""}}.encode(),
+		exportCursor{Last: []string{"", "zz", "", ""}}.encode(),
+	} {
+		if code, _ := request(t, db, http.MethodGet, "/export?resume="+resume, "", nil); code != http.StatusBadRequest {
+			t.Errorf("export with the bad cursor %s: status %d, want 400", resume, code)
+		}
+	}
+}
+
+func TestPrettyResponseFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&prettyResponseWriter{ResponseWriter: rec}).Flush()
+	if !rec.Flushed {
+		t.Error("the pretty response writer should flush the underlying writer")
+	}
+
+	db := openTestDB(t, 4)
+	if _, flushed := export(t, db, "pretty=true"); !flushed {
+		t.Error("the pretty export should be flushed at the checkpoints")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// FollowInterval is the interval to poll the growth of the followed files.
+var FollowInterval = time.Second
+
+// follower follows a growing file like `tail -f`, feeding the complete lines appended to it to the line callback.
+type follower struct {
+	ID      string
+	File    string
+	Label   string
+	Started time.Time
+
+	fd           *os.File
+	offset       int64  // the offset of the partial line, or the end of the last complete line
+	partial      []byte // the trailing line not terminated by a line break yet
+	lineNo       int
+	lines        atomic.Uint64
+	lineCallback func(line string) error
+	onStop       func() error
+
+	cancel chan struct{}
+	done   chan struct{}
+	err    atomic.Value // error string of the stopped follower
+}
+
+// followers are the running followers by their ids, a follower is removed when it stops.
+type followers struct {
+	sync.Mutex
+	m    map[string]*follower
+	next uint64
+}
+
+func (s *pebbleDB) follow(file, label string, lineCallback func(line string) error, onStop func() error) (*follower, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &follower{
+		File: file, Label: label, Started: time.Now(),
+		fd: fd, lineCallback: lineCallback, onStop: onStop,
+		cancel: make(chan struct{}), done: make(chan struct{}),
+	}
+	// scan the current contents before returning, the lines appended later are picked up by polling.
+	if err := f.poll(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	s.follows.Lock()
+	if s.follows.m == nil {
+		s.follows.m = map[string]*follower{}
+	}
+	s.follows.next++
+	f.ID = "f" + strconv.FormatUint(s.follows.next, 10)
+	s.follows.m[f.ID] = f
+	s.follows.Unlock()
+
+	s.jobs.Add(1)
+	go s.runFollower(f)
+	return f, nil
+}
+
+func (s *pebbleDB) runFollower(f *follower) {
+	defer s.jobs.Done()
+	defer close(f.done)
+
+	ticker := time.NewTicker(FollowInterval)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil {
+		select {
+		case <-s.closing:
+			err = errClosing
+		case <-f.cancel:
+			err = fmt.Errorf("cancelled")
+		case <-ticker.C:
+			err = f.poll()
+		}
+	}
+
+	f.err.Store(err.Error())
+	// the follower stopped by its own error is removed too, not only the cancelled one.
+	s.follows.Lock()
+	if s.follows.m[f.ID] == f {
+		delete(s.follows.m, f.ID)
+	}
+	s.follows.Unlock()
+	f.fd.Close()
+	if stopErr := f.onStop(); stopErr != nil {
+		logAt(LevelError, "stop follower %s failed: %v", f.ID, stopErr)
+	}
+	logAt(LevelInfo, "follower %s of file %s stopped, lines: %d, reason: %v", f.ID, f.File, f.lines.Load(), err)
+}
+
+// poll reads the contents appended since the last poll, feeding the complete lines to the line callback
+// and keeping the trailing partial line until it is completed by a line break in the later polls.
+func (f *follower) poll() error {
+	stat, err := f.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < f.offset+int64(len(f.partial)) {
+		logAt(LevelWarn, "followed file %s is truncated, follow it from the beginning", f.File)
+		f.offset, f.partial, f.lineNo = 0, nil, 0
+		if _, err := f.fd.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	buffer := make([]byte, 16*1024)
+	for {
+		n, err := f.fd.Read(buffer)
+		f.partial = append(f.partial, buffer[:n]...)
+		for {
+			i := bytes.IndexByte(f.partial, '\n')
+			if i < 0 {
+				break
+			}
+
+			f.lineNo++
+			if line := bytes.TrimSpace(f.partial[:i]); len(line) > 0 {
+				f.lines.Add(1)
+				if err := f.lineCallback(string(line)); err != nil {
+					return annotateLineError(err, f.File, f.lineNo, int(f.offset))
+				}
+			}
+			f.offset += int64(i + 1)
+			f.partial = f.partial[i+1:]
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (f *follower) status() H {
+	h := H{"id": f.ID, "file": f.File, "label": f.Label, "started": f.Started, "lines": f.lines.Load()}
+	if err, ok := f.err.Load().(string); ok {
+		h["stopped"] = err
+	}
+	return h
+}
+
+// Follows lists the followers.
+func (s *pebbleDB) Follows(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+	s.follows.Lock()
+	list := make([]H, 0, len(s.follows.m))
+	for _, f := range s.follows.m {
+		list = append(list, f.status())
+	}
+	s.follows.Unlock()
+
+	return jsonResponse(w, H{"follows": list})
+}
+
+// CancelFollow stops the follower if it is running, and removes it.
+func (s *pebbleDB) CancelFollow(w http.ResponseWriter, _ *http.Request, p httprouter.Params) error {
+	id := p.ByName("id")
+	s.follows.Lock()
+	f := s.follows.m[id]
+	delete(s.follows.m, id)
+	s.follows.Unlock()
+	if f == nil {
+		return &StatusError{Code: http.StatusNotFound, Err: fmt.Errorf("follower %s not found", id)}
+	}
+
+	select {
+	case <-f.done:
+	default:
+		close(f.cancel)
+		<-f.done
+	}
+	return jsonResponse(w, f.status())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// appendTestFile appends the content to the file.
+func appendTestFile(t *testing.T, name, content string) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitLabeled waits until the mobile is labeled, or fails the test after a while.
+func waitLabeled(t *testing.T, db *pebbleDB, mobile string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		db.Barrier()
+		if len(labelsOf(t, db, mobile)) > 0 {
+			return
+		}
+	}
+	t.Fatalf("%s is not labeled by the follower", mobile)
+}
+
+func TestLoadFollow(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &FollowInterval, 10*time.Millisecond)
+	db := openTestDB(t, 4)
+	// the trailing line is being written.
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n1380000000")
+
+	var res struct {
+		Follow string `json:"follow"`
+		Lines  uint64 `json:"lines"`
+	}
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/vip?follow=y", "", &res)
+	if res.Follow == "" || res.Lines != 2 {
+		t.Fatalf("follow %q of %d lines, want a follower of the 2 complete lines", res.Follow, res.Lines)
+	}
+	db.Barrier()
+	if got := labelsOf(t, db, "13800000002"); len(got) != 1 {
+		t.Errorf("labels of 13800000002 are %v, want [vip] by the initial scan", got)
+	}
+
+	// the partial line is completed by the append.
+	appendTestFile(t, "a.txt", "3\n13800000004\n")
+	waitLabeled(t, db, "13800000004")
+	waitLabeled(t, db, "13800000003")
+	if got := labelsOf(t, db, "1380000000"); len(got) != 0 {
+		t.Errorf("the partial line is loaded as %v", got)
+	}
+
+	var list struct {
+		Follows []struct {
+			ID    string `json:"id"`
+			Lines uint64 `json:"lines"`
+		} `json:"follows"`
+	}
+	mustRequest(t, db, http.MethodGet, "/follows", "", &list)
+	if len(list.Follows) != 1 || list.Follows[0].ID != res.Follow || list.Follows[0].Lines != 4 {
+		t.Errorf("follows %+v, want %s of 4 lines", list.Follows, res.Follow)
+	}
+
+	// the lines appended after the cancel are not loaded.
+	var stopped struct {
+		Stopped string `json:"stopped"`
+	}
+	mustRequest(t, db, http.MethodDelete, "/follows/"+res.Follow, "", &stopped)
+	if stopped.Stopped == "" {
+		t.Error("the cancelled follower should be stopped")
+	}
+	appendTestFile(t, "a.txt", "13800000005\n")
+	time.Sleep(5 * FollowInterval)
+	db.Barrier()
+	if got := labelsOf(t, db, "13800000005"); len(got) != 0 {
+		t.Errorf("labels of 13800000005 are %v after the cancel, want none", got)
+	}
+	if code, _ := request(t, db, http.MethodDelete, "/follows/"+res.Follow, "", nil); code != http.StatusNotFound {
+		t.Errorf("cancel of the removed follower: status %d, want 404", code)
+	}
+}
+
+func TestLoadFollowRemovedOnError(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &FollowInterval, 10*time.Millisecond)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	writeTestFile(t, "a.txt.gz", "")
+
+	if code, _ := request(t, db, http.MethodPost, "/load/a.txt.gz/vip?follow=y", "", nil); code != http.StatusBadRequest {
+		t.Errorf("follow of the gzip file: status %d, want 400", code)
+	}
+
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/vip?follow=y", "", nil)
+	// the bad line stops the follower, which is removed on its own.
+	appendTestFile(t, "a.txt", "abc\n")
+	var list struct {
+		Follows []struct {
+			ID string `json:"id"`
+		} `json:"follows"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mustRequest(t, db, http.MethodGet, "/follows", "", &list)
+		if len(list.Follows) == 0 {
+			return
+		}
+	}
+	t.Errorf("follows %+v, want the failed follower removed", list.Follows)
+}
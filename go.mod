@@ -3,10 +3,12 @@ module github.com/bingoohuang/labeldb
 go 1.19
 
 require (
-	github.com/cespare/xxhash/v2 v2.1.1
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/cockroachdb/pebble v0.0.0-20220809135203-cb25d247e7c2
+	github.com/gorilla/websocket v1.4.0
 	github.com/julienschmidt/httprouter v1.3.0
 	go.uber.org/multierr v1.8.0
+	golang.org/x/text v0.3.3
 )
 
 require (
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// hllPrecision is the precision of the HyperLogLog, with 2^14 registers the standard error is about 0.8%.
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of the distinct items approximately, safe for concurrent use.
+type HyperLogLog struct {
+	regs [1 << hllPrecision]uint32
+}
+
+// Add adds the item to the HyperLogLog.
+func (h *HyperLogLog) Add(item []byte) {
+	x := Hash(item)
+	idx := x >> (64 - hllPrecision)
+	rho := uint32(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+
+	reg := &h.regs[idx]
+	for {
+		old := atomic.LoadUint32(reg)
+		if rho <= old || atomic.CompareAndSwapUint32(reg, old, rho) {
+			return
+		}
+	}
+}
+
+// Count returns the estimated number of the distinct items.
+func (h *HyperLogLog) Count() uint64 {
+	const m = float64(len(h.regs))
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for i := range h.regs {
+		v := atomic.LoadUint32(&h.regs[i])
+		sum += 1 / float64(uint64(1)<<v)
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros)) // linear counting for the small cardinalities.
+	}
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary marshals the registers to bytes.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	b := make([]byte, len(h.regs))
+	for i := range h.regs {
+		b[i] = byte(atomic.LoadUint32(&h.regs[i]))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary merges the registers from bytes, the bytes of a different precision are ignored.
+func (h *HyperLogLog) UnmarshalBinary(b []byte) error {
+	if len(b) != len(h.regs) {
+		return nil
+	}
+	for i, v := range b {
+		if uint32(v) > h.regs[i] {
+			h.regs[i] = uint32(v)
+		}
+	}
+	return nil
+}
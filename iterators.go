@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// MaxPartitionIterators is the max number of the open iterators per partition, 0 for unlimited.
+var MaxPartitionIterators = 0
+
+// IteratorWait is the max time to wait for an iterator slot of a saturated partition, 0 to reject immediately.
+var IteratorWait time.Duration
+
+// iteratorSlots limits the open iterators per partition, to protect a hot partition from a thundering herd.
+type iteratorSlots []chan struct{}
+
+func newIteratorSlots(partitions uint64) iteratorSlots {
+	if MaxPartitionIterators <= 0 {
+		return nil
+	}
+
+	slots := make(iteratorSlots, partitions)
+	for i := range slots {
+		slots[i] = make(chan struct{}, MaxPartitionIterators)
+	}
+	return slots
+}
+
+// acquire acquires an iterator slot of the partition, waiting up to IteratorWait,
+// the returned release func should be called after the iterator is closed.
+func (t iteratorSlots) acquire(partition uint64) (release func(), err error) {
+	if t == nil {
+		return func() {}, nil
+	}
+
+	slot := t[partition]
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	default:
+	}
+
+	if IteratorWait > 0 {
+		timer := time.NewTimer(IteratorWait)
+		defer timer.Stop()
+
+		select {
+		case slot <- struct{}{}:
+			return func() { <-slot }, nil
+		case <-timer.C:
+		}
+	}
+
+	return nil, &StatusError{
+		Code: http.StatusServiceUnavailable,
+		Err:  fmt.Errorf("partition %d is saturated with %d open iterators", partition, cap(slot)),
+	}
+}
+
+// scanPartition scans the partition by scanBatches within an iterator slot.
+func (s *pebbleDB) scanPartition(partition int, fn func(batch []KV) error) error {
+	return s.scanPartitionRange(partition, nil, fn)
+}
+
+// scanPartitionRange scans the key range of opts in the partition by scanBatches within an iterator slot,
+// and an I/O slot of its disk.
+func (s *pebbleDB) scanPartitionRange(partition int, opts *pebble.IterOptions, fn func(batch []KV) error) error {
+	release, err := s.iterSlots.acquire(uint64(partition))
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer s.diskOf(uint64(partition)).acquire()()
+
+	return scanBatches(s.dbs[partition], opts, fn)
+}
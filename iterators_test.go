@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIteratorSlots(t *testing.T) {
+	setGlobal(t, &MaxPartitionIterators, 2)
+	setGlobal(t, &IteratorWait, 0)
+	slots := newIteratorSlots(2)
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := slots.acquire(0)
+		if err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	// the next one of the saturated partition is rejected.
+	if _, err := slots.acquire(0); err == nil {
+		t.Fatal("acquire of the saturated partition should be rejected")
+	} else if se, ok := err.(*StatusError); !ok || se.Code != http.StatusServiceUnavailable {
+		t.Errorf("error %v, want 503", err)
+	}
+	// the other partition is not affected.
+	release, err := slots.acquire(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the next one waits for a released slot.
+	IteratorWait = time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		releases[0]()
+	}()
+	start := time.Now()
+	if release, err = slots.acquire(0); err != nil {
+		t.Fatalf("acquire waiting for the released slot: %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Error("acquire should wait for the released slot")
+	}
+	release()
+	releases[1]()
+
+	// the unlimited slots never reject.
+	MaxPartitionIterators = 0
+	if _, err := newIteratorSlots(2).acquire(0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuerySaturatedPartition(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &MaxPartitionIterators, 1)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	load(t, db, "a.txt", "vip", "")
+
+	mobile, _ := mobile2bytes("13800000001")
+	release, err := db.iterSlots.acquire(db.Partition(mobile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, errMsg := request(t, db, http.MethodGet, "/labels/13800000001", "", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, error %q of the saturated partition, want 503", code, errMsg)
+	}
+
+	release()
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 {
+		t.Errorf("labels %v after the slot released, want [vip]", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The labels of a mobile are a set, each member is a key of the mobile prefix with the label as the suffix,
+// and the metadata of the member (see LabelValue) as the value. The writes are queued in the op channel of the
+// partition, so they are visible to the reads only after they are applied (see Barrier).
+
+// Add adds the label to the label set of the mobile, storing value (maybe nil) with it.
+func (s *pebbleDB) Add(mobile, label, value []byte) error {
+	return s.AppendWithValue(mobile, label, value)
+}
+
+// Remove removes the label from the label set of the mobile, returns whether the label was a member.
+// The removed key is released from the quota of the label.
+func (s *pebbleDB) Remove(mobile, label []byte) (bool, error) {
+	if s.readonly {
+		return false, ErrReadOnly
+	}
+	partition := s.Partition(mobile)
+	key := append(append([]byte{}, mobile...), label...)
+	// the value sizes the released quota, read before the delete is queued.
+	var value []byte
+	if s.quotaTracker(string(label)) != nil {
+		s.barrierPartition(partition)
+		if v, closer, err := s.dbs[partition].Get(key); err == nil {
+			value = append(value, v...)
+			closer.Close()
+		} else if err != pebble.ErrNotFound {
+			return false, err
+		}
+	}
+	var applied bool
+	done := make(chan struct{})
+	s.opc(partition) <- op{typ: opDelete, partition: partition, key: key, done: done, applied: &applied}
+	<-done
+	if applied {
+		return true, s.releaseQuota(mobile, label, value)
+	}
+	return false, nil
+}
+
+// Contains tells whether the label is a member of the label set of the mobile.
+func (s *pebbleDB) Contains(mobile, label []byte) (bool, error) {
+	key := append(append(make([]byte, 0, len(mobile)+len(label)), mobile...), label...)
+	_, closer, err := s.dbs[s.Partition(mobile)].Get(key)
+	if err == pebble.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, closer.Close()
+}
+
+// Members returns the labels in the label set of the mobile, in the order of the labels.
+func (s *pebbleDB) Members(mobile []byte) ([]string, error) {
+	return s.FindLabelsByMobile(mobile)
+}
+
+// RemoveLabel removes the label from the mobile.
+func (s *pebbleDB) RemoveLabel(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	mobile, err := mobile2bytes(p.ByName("mobile"))
+	if err != nil {
+		return err
+	}
+	label := NormalizeLabel(p.ByName("label"))
+
+	removed, err := s.Remove(ds.key(mobile), []byte(label))
+	if err != nil {
+		return err
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "mobile": bytes2mobile(mobile), "label": label, "removed": removed})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLabelSet(t *testing.T) {
+	db := openTestDB(t, 4)
+	mobile, _ := mobile2bytes("13800000001")
+	other, _ := mobile2bytes("13800000002")
+
+	for _, label := range []string{"vip", "big", "vip"} {
+		if err := db.Add(mobile, []byte(label), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Add(other, []byte("new"), nil); err != nil {
+		t.Fatal(err)
+	}
+	db.Barrier()
+
+	members, err := db.Members(mobile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(members, ","); got != "big,vip" {
+		t.Errorf("members %s, want big,vip with the duplicate added once", got)
+	}
+
+	for label, want := range map[string]bool{"vip": true, "big": true, "new": false, "vi": false} {
+		if ok, err := db.Contains(mobile, []byte(label)); err != nil || ok != want {
+			t.Errorf("contains %s: %t, %v, want %t", label, ok, err, want)
+		}
+	}
+
+	for i, want := range []bool{true, false} {
+		if removed, err := db.Remove(mobile, []byte("vip")); err != nil || removed != want {
+			t.Errorf("remove %d of vip: %t, %v, want %t", i, removed, err, want)
+		}
+	}
+	if ok, _ := db.Contains(mobile, []byte("vip")); ok {
+		t.Error("the removed vip is still a member")
+	}
+	if members, _ := db.Members(other); len(members) != 1 || members[0] != "new" {
+		t.Errorf("members of the other mobile are %v, want [new]", members)
+	}
+
+	var res struct {
+		Removed bool `json:"removed"`
+	}
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000001/big", "", &res)
+	if !res.Removed {
+		t.Error("big should be removed by the api")
+	}
+	if got := labelsOf(t, db, "13800000001"); len(got) != 0 {
+		t.Errorf("labels %v after removing all the members, want none", got)
+	}
+}
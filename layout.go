@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	// LayoutFlat places the partitions as sibling dirs path.0, path.1, ...
+	LayoutFlat = "flat"
+	// LayoutNested places the partitions in per-partition sub dirs path/0000/db, path/0001/db, ...
+	LayoutNested = "nested"
+)
+
+// PartitionLayout is the layout of the partition dirs, empty for the persisted one or LayoutFlat for a new db.
+var PartitionLayout string
+
+// PartitionBaseDirs spreads the partitions round-robin across the base dirs (like the mount points of disks),
+// instead of the dir of the db path. It is not persisted, so it must be the same across restarts.
+var PartitionBaseDirs []string
+
+// ParsePartitionLayout validates the layout name.
+func ParsePartitionLayout(v string) (string, error) {
+	switch v {
+	case LayoutFlat, LayoutNested:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unknown partition layout %q, expect %s or %s", v, LayoutFlat, LayoutNested)
+	}
+}
+
+// partitionDir returns the dir of the partition by the PartitionLayout and PartitionBaseDirs.
+func partitionDir(path string, partition uint64) string {
+	if n := uint64(len(PartitionBaseDirs)); n > 0 {
+		path = filepath.Join(PartitionBaseDirs[partition%n], filepath.Base(path))
+	}
+	if PartitionLayout == LayoutNested {
+		return filepath.Join(path, fmt.Sprintf("%04d", partition), "db")
+	}
+	return fmt.Sprintf("%s.%d", path, partition)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// hasDB tells whether the pebble db is under the dir.
+func hasDB(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "CURRENT"))
+	return err == nil
+}
+
+func TestNestedLayout(t *testing.T) {
+	inTempDir(t)
+	path := filepath.Join(t.TempDir(), "db")
+	setGlobal(t, &PartitionLayout, LayoutNested)
+	db := openTestDBAt(t, path, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 100))
+	load(t, db, "a.txt", "vip", "durable=y")
+
+	for _, p := range []string{"0000", "0001", "0002", "0003"} {
+		if !hasDB(filepath.Join(path, p, "db")) {
+			t.Errorf("partition %s is not under %s", p, filepath.Join(path, p, "db"))
+		}
+	}
+	if hasDB(path + ".0") {
+		t.Error("partition 0 is in the flat layout")
+	}
+
+	// the layout is persisted in the meta and adopted on reopen.
+	PartitionLayout = ""
+	db = reopenTestDB(t, db)
+	if PartitionLayout != LayoutNested {
+		t.Errorf("layout %q after reopen, want the persisted nested", PartitionLayout)
+	}
+	if n := countLabeled(t, db, 13800000000, 100, "vip"); n != 100 {
+		t.Errorf("%d mobiles labeled after reopen, want 100", n)
+	}
+}
+
+func TestPartitionBaseDirs(t *testing.T) {
+	disks := []string{t.TempDir(), t.TempDir()}
+	setGlobal(t, &PartitionBaseDirs, disks)
+	openTestDBAt(t, filepath.Join(t.TempDir(), "db"), 4)
+
+	// the partitions are spread round-robin across the base dirs.
+	for p := 0; p < 4; p++ {
+		dir := filepath.Join(disks[p%2], fmt.Sprintf("db.%d", p))
+		if !hasDB(dir) {
+			t.Errorf("partition %d is not in %s", p, dir)
+		}
+	}
+}
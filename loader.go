@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/multierr"
+)
+
+// lineLoader writes the lines of a load with the label, it is shared by the file loads and the stream loads,
+// so that both apply the same record formats, transforms, comment skipping, collision policy and quotas.
+type lineLoader struct {
+	s     *pebbleDB
+	label string // the label in the url
+	ds    dataset
+	noop  bool
+	bulk  *bulkLoader // nil for the writes through the op channels
+
+	transforms    Transforms
+	commentPrefix string
+	ndjson        *NDJSONFields
+	// mixed parses only the lines starting with { as the JSON records, the others as the mobiles, for the streams.
+	mixed bool
+	lv    LabelValue
+	value []byte // lv encoded
+
+	// lineLabel is the label of the lines, changed per entry of a tar archive with entry_label=y, which is scanned sequentially.
+	lineLabel string
+	// quotas are the quotas of the record labels, nil for the labels without quota, see quotaOf.
+	quotasMu sync.Mutex
+	quotas   map[string]*loadQuota
+	// touched are the partitions written by the load.
+	touched []atomic.Bool
+
+	// lines are the parsed lines, which end up as written, skipped (empty, comment, over quota or noop)
+	// or rejected (malformed records or collisions), when the load completes.
+	lines, size, written, skipped, comments, rejected, malformed, collisions atomic.Uint64
+	// committed are the bytes of the lines handled without error, see LoadProgress.Committed.
+	committed   atomic.Uint64
+	firstReject atomic.Value
+	// onReject is called with the error of each malformed record, if not nil.
+	onReject func(err error)
+}
+
+// newLineLoader creates the lineLoader of the label by the query params of the request,
+// source is the file name stored with with_source=y, empty for the streams.
+func (s *pebbleDB) newLineLoader(r *http.Request, label, source string) (*lineLoader, error) {
+	q := r.URL.Query()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return nil, err
+	}
+	l := &lineLoader{s: s, label: label, lineLabel: label, ds: ds, touched: make([]atomic.Bool, len(s.dbs))}
+	if l.transforms, err = ParseTransforms(q.Get("transform")); err != nil {
+		return nil, err
+	}
+	l.commentPrefix = CommentPrefix
+	if q.Has("comment") {
+		l.commentPrefix = q.Get("comment")
+	}
+	switch format := q.Get("format"); format {
+	case "", "lines":
+	case "ndjson":
+		l.ndjson = &NDJSONFields{Mobile: "mobile", Label: "label"}
+		if f := q.Get("mobile_field"); f != "" {
+			l.ndjson.Mobile = f
+		}
+		if f := q.Get("label_field"); f != "" {
+			l.ndjson.Label = f
+		}
+	default:
+		return nil, &StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("unknown format %q", format)}
+	}
+
+	if source != "" && IsBool(q.Get("with_source")) {
+		l.lv.Source = filepath.Base(source)
+	}
+	l.lv.Batch = q.Get("batch_id")
+	if l.lv != (LabelValue{}) {
+		l.value = l.lv.Encode()
+	}
+	return l, nil
+}
+
+// reject counts the malformed record as rejected, instead of failing the whole load.
+func (l *lineLoader) reject(err error) {
+	l.rejected.Add(1)
+	if l.malformed.Add(1) == 1 {
+		logAt(LevelWarn, "reject record: %v", err)
+		l.firstReject.Store(err.Error())
+	}
+	if l.onReject != nil {
+		l.onReject(err)
+	}
+}
+
+// callback returns the line callback of the load, throttled by IngestRate together with the other loads.
+func (l *lineLoader) callback() func(line string) error {
+	return l.s.throttle.throttle(IngestRate, func(line string) error {
+		if err := l.handle(line); err != nil {
+			return err
+		}
+		l.committed.Add(uint64(len(line) + 1))
+		return nil
+	})
+}
+
+// handle writes the line, it is called concurrently by the workers scanning the chunks of a file.
+func (l *lineLoader) handle(line string) error {
+	s := l.s
+	l.lines.Add(1)
+	l.size.Add(uint64(len(line) + 1))
+	if skipLine(line, l.commentPrefix) {
+		l.comments.Add(1)
+		l.skipped.Add(1)
+		return nil
+	}
+	if l.noop {
+		l.skipped.Add(1)
+		return nil
+	}
+
+	recLabel := l.lineLabel
+	ndjson := l.ndjson
+	if l.mixed && !strings.HasPrefix(line, "{") {
+		ndjson = nil
+	}
+	if ndjson != nil {
+		var err error
+		if line, recLabel, err = ndjson.parse(line, l.lineLabel); err != nil {
+			l.reject(err)
+			return nil
+		}
+	}
+	line = l.transforms.Apply(line)
+	mobile, err := mobile2bytes(line)
+	if err != nil {
+		// a bad mobile fails the line format load, pointing out the line to fix,
+		// but is a malformed record of the record formats and the streams.
+		if l.ndjson != nil {
+			l.reject(err)
+			return nil
+		}
+		return err
+	}
+	mobile = l.ds.key(mobile)
+	value := l.value
+	if Collisions != CollisionOff {
+		sv := l.lv
+		sv.Sum = mobileSum(line)
+		value = sv.Encode()
+		collided, err := s.collides(mobile, []byte(recLabel), sv.Sum)
+		if err != nil {
+			return err
+		}
+		if collided {
+			l.collisions.Add(1)
+			switch Collisions {
+			case CollisionReject:
+				l.rejected.Add(1)
+				return nil
+			case CollisionLog:
+				logAt(LevelWarn, "mobile %s with label %s collides with an existing key, overwritten", line, recLabel)
+			}
+		}
+	}
+	if q := l.quotaOf(recLabel); q != nil && !q.admit(s, mobile, []byte(recLabel), value) {
+		q.overflow.Add(1)
+		l.skipped.Add(1)
+		return nil
+	}
+	partition := s.Partition(mobile)
+	l.touched[partition].Store(true)
+	if l.bulk != nil {
+		s.hll.Add(mobile)
+		if err := l.bulk.add(partition, append(mobile, recLabel...), value); err != nil {
+			return err
+		}
+	} else if err := s.Add(mobile, []byte(recLabel), value); err != nil {
+		return err
+	}
+	l.written.Add(1)
+	return nil
+}
+
+// touchedPartitions returns the partitions written by the load.
+func (l *lineLoader) touchedPartitions() (partitions []uint64) {
+	for i := range l.touched {
+		if l.touched[i].Load() {
+			partitions = append(partitions, uint64(i))
+		}
+	}
+	return partitions
+}
+
+// barrier waits until the queued writes of the load are applied, only in the partitions written by the load.
+func (l *lineLoader) barrier() {
+	for _, p := range l.touchedPartitions() {
+		l.s.barrierPartition(p)
+	}
+}
+
+// loadQuota is the shared quota tracker of a label, with the keys of the label dropped by the load.
+type loadQuota struct {
+	*quotaTracker
+	overflow atomic.Uint64
+}
+
+// quotaOf returns the quota of the record label, or nil if the label has no quota.
+func (l *lineLoader) quotaOf(label string) *loadQuota {
+	l.quotasMu.Lock()
+	defer l.quotasMu.Unlock()
+
+	q, ok := l.quotas[label]
+	if !ok {
+		if t := l.s.quotaTracker(label); t != nil {
+			q = &loadQuota{quotaTracker: t}
+		}
+		if l.quotas == nil {
+			l.quotas = map[string]*loadQuota{}
+		}
+		l.quotas[label] = q
+	}
+	return q
+}
+
+// usedQuotas returns the quotas of the labels written by the load, in the order of the labels.
+func (l *lineLoader) usedQuotas() (quotas []*loadQuota) {
+	l.quotasMu.Lock()
+	defer l.quotasMu.Unlock()
+
+	for _, q := range l.quotas {
+		if q != nil {
+			quotas = append(quotas, q)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].label < quotas[j].label })
+	return quotas
+}
+
+// saveQuotas persists the usage of the quotas of the labels written by the load.
+func (l *lineLoader) saveQuotas() (err error) {
+	for _, q := range l.usedQuotas() {
+		err = multierr.Append(err, q.save(&l.s.meta))
+	}
+	return err
+}
+
+// warnings returns the non-fatal issues of the lines, reported in the response.
+func (l *lineLoader) warnings() (warnings []string) {
+	for _, q := range l.usedQuotas() {
+		warnings = append(warnings, q.warnings(q.overflow.Load())...)
+	}
+	if n := l.comments.Load(); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d empty or comment lines are skipped", n))
+	}
+	if n := l.collisions.Load(); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d keys collide with the keys written from other mobile strings, policy %s", n, Collisions))
+	}
+	if n := l.malformed.Load(); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d malformed records are rejected, the first: %s", n, l.firstReject.Load()))
+	}
+	return warnings
+}
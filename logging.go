@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// LogLevel is the level of the logs, the logs below the current level are discarded.
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l >= LevelDebug && int(l) < len(logLevelNames) {
+		return logLevelNames[l]
+	}
+	return fmt.Sprintf("level(%d)", l)
+}
+
+// ParseLogLevel parses the level name, debug/info/warn/error.
+func ParseLogLevel(v string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(v, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expect one of %s", v, strings.Join(logLevelNames, "/"))
+}
+
+var logLevel atomic.Int32
+
+func init() { logLevel.Store(int32(LevelInfo)) }
+
+// CurrentLogLevel returns the current log level.
+func CurrentLogLevel() LogLevel { return LogLevel(logLevel.Load()) }
+
+// SetLogLevel sets the log level, returns the previous one.
+func SetLogLevel(l LogLevel) (prev LogLevel) { return LogLevel(logLevel.Swap(int32(l))) }
+
+// logAt logs at the level, discarded when the level is below the current one.
+func logAt(l LogLevel, format string, v ...any) {
+	if l >= CurrentLogLevel() {
+		log.Printf(l.String()+" "+format, v...)
+	}
+}
+
+// queryCounters counts the requests by the route and the status code.
+type queryCounters struct {
+	sync.Mutex
+	counts map[string]*routeCounter
+}
+
+type routeCounter struct {
+	Requests uint64         `json:"requests"`
+	Errors   uint64         `json:"errors"`
+	Status   map[int]uint64 `json:"status"`
+}
+
+var queries = queryCounters{counts: map[string]*routeCounter{}}
+
+func (q *queryCounters) add(route string, status int) {
+	q.Lock()
+	defer q.Unlock()
+
+	c := q.counts[route]
+	if c == nil {
+		c = &routeCounter{Status: map[int]uint64{}}
+		q.counts[route] = c
+	}
+	c.Requests++
+	if status >= http.StatusBadRequest {
+		c.Errors++
+	}
+	c.Status[status]++
+}
+
+func (q *queryCounters) snapshot() map[string]routeCounter {
+	q.Lock()
+	defer q.Unlock()
+
+	m := make(map[string]routeCounter, len(q.counts))
+	for route, c := range q.counts {
+		status := make(map[int]uint64, len(c.Status))
+		for code, n := range c.Status {
+			status[code] = n
+		}
+		m[route] = routeCounter{Requests: c.Requests, Errors: c.Errors, Status: status}
+	}
+	return m
+}
+
+// accessRecorder records the status code and the size of the response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *accessRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *accessRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Flush flushes the underlying writer if it supports, for the streaming responses.
+func (r *accessRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// routeOf restores the route pattern from the path, by replacing the param values with their :names in order,
+// e.g. /labels/13800000000 to /labels/:mobile.
+func routeOf(path string, p httprouter.Params) string {
+	segments := strings.Split(path, "/")
+	j := 0
+	for i := range segments {
+		if j < len(p) && segments[i] == p[j].Value {
+			segments[i] = ":" + p[j].Key
+			j++
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// accessLog counts the request in the query counters and logs it at the info level.
+// The param values, like the mobiles, are redacted when the request carries an auth token.
+func accessLog(rec *accessRecorder, r *http.Request, p httprouter.Params, start time.Time) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	route := routeOf(r.URL.Path, p)
+	queries.add(r.Method+" "+route, rec.status)
+
+	if LevelInfo < CurrentLogLevel() {
+		return
+	}
+
+	path := r.URL.Path
+	var params strings.Builder
+	redact := r.Header.Get("Authorization") != ""
+	if redact {
+		path = route
+	}
+	for _, param := range p {
+		value := param.Value
+		if redact {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&params, " %s=%q", param.Key, value)
+	}
+	logAt(LevelInfo, "access method=%s path=%q%s status=%d latency=%s size=%d remote=%s",
+		r.Method, path, params.String(), rec.status, time.Since(start), rec.size, r.RemoteAddr)
+}
+
+// Stats responds the query counters by the routes, the goroutine count and the I/O of the disks.
+func (s *pebbleDB) Stats(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+	counts := queries.snapshot()
+	var total uint64
+	for _, c := range counts {
+		total += c.Requests
+	}
+	disks := make([]H, len(s.disks))
+	for i, d := range s.disks {
+		disks[i] = d.stats()
+	}
+	return jsonResponse(w, H{"requests": total, "queries": counts, "goroutines": runtime.NumGoroutine(), "disks": disks})
+}
+
+// AdminLogLevel changes the log level at runtime by the query param level=debug|info|warn|error,
+// responds the previous and the new levels.
+func AdminLogLevel(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	l, err := ParseLogLevel(r.URL.Query().Get("level"))
+	if err != nil {
+		return err
+	}
+
+	prev := SetLogLevel(l)
+	log.Printf("log level changed from %s to %s by %s", prev, l, r.RemoteAddr)
+	return jsonResponse(w, H{"previous": prev.String(), "level": l.String()})
+}
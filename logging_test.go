@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// captureLog captures the logs for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &buf
+}
+
+func TestAccessLog(t *testing.T) {
+	db := openTestDB(t, 4)
+	setGlobal(t, &AdminToken, "secret")
+	buf := captureLog(t)
+	before := queries.snapshot()["GET /labels/:mobile"].Requests
+
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001", "", nil)
+	line := buf.String()
+	for _, field := range []string{"info access", "method=GET", `path="/labels/13800000001"`, `mobile="13800000001"`, "status=200", "latency=", "size="} {
+		if !strings.Contains(line, field) {
+			t.Errorf("access log %q, want the field %s", line, field)
+		}
+	}
+	if n := queries.snapshot()["GET /labels/:mobile"].Requests; n != before+1 {
+		t.Errorf("%d requests counted, want %d", n, before+1)
+	}
+
+	// the param values are redacted when the request carries an auth token.
+	buf.Reset()
+	r := httptest.NewRequest(http.MethodGet, "/labels/13800000001", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	serve(t, db, r, nil)
+	if line := buf.String(); strings.Contains(line, "13800000001") || !strings.Contains(line, `path="/labels/:mobile" mobile="[redacted]"`) {
+		t.Errorf("access log %q, want the mobile redacted", line)
+	}
+
+	// the access log respects the log level, but the request is still counted.
+	prev := SetLogLevel(LevelWarn)
+	defer SetLogLevel(prev)
+	buf.Reset()
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001", "", nil)
+	if buf.Len() > 0 {
+		t.Errorf("access log %q at the warn level, want none", buf)
+	}
+	if n := queries.snapshot()["GET /labels/:mobile"].Requests; n != before+3 {
+		t.Errorf("%d requests counted, want %d", n, before+3)
+	}
+}
+
+func TestRouteOf(t *testing.T) {
+	p := httprouter.Params{{Key: "mobile", Value: "13800000001"}, {Key: "label", Value: "vip"}}
+	if got := routeOf("/labels/13800000001/vip", p); got != "/labels/:mobile/:label" {
+		t.Errorf("route %s, want /labels/:mobile/:label", got)
+	}
+	if got := routeOf("/stats", nil); got != "/stats" {
+		t.Errorf("route %s, want /stats", got)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	db := openTestDB(t, 4)
+	setGlobal(t, &AdminToken, "secret")
+	t.Cleanup(func() { SetLogLevel(LevelInfo) })
+	buf := captureLog(t)
+
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001", "", nil)
+	if strings.Contains(buf.String(), "debug get labels") {
+		t.Errorf("debug log %q at the info level", buf)
+	}
+
+	if code, _ := adminRequest(t, db, http.MethodPost, "/admin/loglevel?level=debug", "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("change without the token: status %d, want 401", code)
+	}
+	if code, _ := adminRequest(t, db, http.MethodPost, "/admin/loglevel?level=verbose", "", "secret", nil); code != http.StatusBadRequest {
+		t.Errorf("change to the unknown level: status %d, want 400", code)
+	}
+
+	var res struct {
+		Previous string `json:"previous"`
+		Level    string `json:"level"`
+	}
+	if code, _ := adminRequest(t, db, http.MethodPost, "/admin/loglevel?level=debug", "", "secret", &res); code != http.StatusOK || res.Previous != "info" || res.Level != "debug" {
+		t.Fatalf("change to debug: status %d, %+v, want 200 from info to debug", code, res)
+	}
+	buf.Reset()
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001", "", nil)
+	if !strings.Contains(buf.String(), "debug get labels of mobile 13800000001") {
+		t.Errorf("log %q after the change to debug, want the debug log", buf)
+	}
+}
+
+func TestErrorLevelSilencesLoad(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	t.Cleanup(func() { SetLogLevel(LevelInfo) })
+	buf := captureLog(t)
+
+	load(t, db, "a.txt", "vip", "")
+	if !strings.Contains(buf.String(), "info load file: a.txt") {
+		t.Errorf("log %q at the info level, want the info lines of the load", buf)
+	}
+
+	SetLogLevel(LevelError)
+	buf.Reset()
+	load(t, db, "a.txt", "vip", "")
+	if buf.Len() > 0 {
+		t.Errorf("log %q at the error level, want the info lines of the load silenced", buf)
+	}
+}
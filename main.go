@@ -1,20 +1,24 @@
 package main
 
 import (
-	"bytes"
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -25,25 +29,132 @@ import (
 
 func main() {
 	pPort := flag.Int("port", 8080, "listen port")
+	pDB := flag.String("db", "labelsdb/db", "db path, the partitions are stored in {db}.0, {db}.1, ...")
+	pMerge := flag.String("merge", "", "merge the comma separated source db paths offline into the db, then exit")
+	flag.StringVar(&AdminToken, "admin-token", "", "token required by the admin endpoints, empty to disable them")
+	flag.DurationVar(&StreamAckInterval, "stream-ack-interval", StreamAckInterval, "interval to ack the persisted records of the streaming load")
+	flag.IntVar(&BacklogHighWater, "backlog-high-water", BacklogHighWater, "high-water mark of the op channels backlog to respond 503, 0 to disable")
+	flag.IntVar(&BacklogRetryAfter, "backlog-retry-after", BacklogRetryAfter, "seconds of Retry-After when responding 503 for backlog")
+	flag.IntVar(&MaxPartitionIterators, "max-partition-iterators", 0, "max open iterators per partition, 0 for unlimited")
+	flag.DurationVar(&IteratorWait, "iterator-wait", 0, "max time to wait for an iterator of a saturated partition, 0 to reject immediately")
+	flag.BoolVar(&WriteSeq, "write-seq", false, "stamp the labels with a per-partition write sequence, returned by with_seq=true")
+	flag.StringVar(&CommentPrefix, "comment-prefix", "", "default prefix of the comment lines skipped by the loads, empty to disable")
+	flag.DurationVar(&LoadProgressInterval, "load-progress-interval", LoadProgressInterval, "interval to record the committed bytes of the loads in the meta")
+	flag.DurationVar(&FollowInterval, "follow-interval", FollowInterval, "interval to poll the growth of the files loaded with follow=true")
+	flag.IntVar(&BulkSpillBytes, "bulk-spill", BulkSpillBytes, "bytes buffered per partition by the bulk loads before spilling to a sorted SSTable, 0 to buffer all in memory")
+	flag.IntVar(&DiskConcurrency, "disk-concurrency", 0, "max concurrent full scans and bulk ingests per disk of -partition-dirs, 0 for unlimited")
+	flag.BoolVar(&Datasets, "datasets", false, "prefix the keys with the dataset of the query param dataset, to share the dbs by datasets, only for a new db")
+	flag.IntVar(&MaxGoroutines, "max-goroutines", 0, "goroutine count above which the loads scan the files sequentially, 0 to disable")
+	flag.IntVar(&ScanWorkers, "scan-workers", ScanWorkers, "default number of the workers to scan a file concurrently, 0 for the number of CPUs")
+	flag.BoolVar(&NormalizeLabels, "normalize-labels", false, "normalize the labels to Unicode NFC on write and query")
+	flag.BoolVar(&Pretty, "pretty", false, "indent the JSON responses, for development")
+	flag.BoolVar(&ReadOnly, "readonly", false, "open the db in read-only mode for the query only replicas")
+	flag.IntVar(&PartitionPrefix, "partition-prefix", PartitionPrefix, "leading decimal digits of the mobile as the partition key, 0 for the whole mobile, -1 for the persisted one")
+	flag.Func("hash-seed", "seed of the xxhash for the partitioning, default the persisted one or 0 for a new db", func(v string) (err error) {
+		HashSeed, err = strconv.ParseUint(v, 10, 64)
+		hashSeedSet = true
+		return err
+	})
+	flag.IntVar(&MobileWidth, "mobile-width", MobileWidth, "fixed width to format mobiles with leading zeros, 0 to disable")
+	flag.IntVar(&ScanBatchSize, "scan-batch", ScanBatchSize, "number of keys per batch in full scans")
+	flag.Func("label-quotas", "quotas of labels like vip=1000,big=0:1048576,*=100000 in the form of label=maxKeys[:maxBytes]", func(v string) (err error) {
+		LabelQuotas, err = ParseLabelQuotas(v)
+		return err
+	})
+	flag.Func("ingest-rate", "max rate to feed the lines of the loads, like 5000/s for lines or 10MB/s for bytes, default unlimited", func(v string) (err error) {
+		IngestRate, err = ParseRate(v)
+		return err
+	})
+	flag.Func("log-level", "log level of debug/info/warn/error, the access logs are at info", func(v string) error {
+		l, err := ParseLogLevel(v)
+		SetLogLevel(l)
+		return err
+	})
+	flag.Func("partition-layout", "layout of the partition dirs, flat for path.N or nested for path/NNNN/db, default the persisted one or flat for a new db", func(v string) (err error) {
+		PartitionLayout, err = ParsePartitionLayout(v)
+		return err
+	})
+	flag.Func("partition-dirs", "comma separated base dirs to spread the partitions across round-robin, like the mount points of disks", func(v string) error {
+		PartitionBaseDirs = strings.Split(v, ",")
+		return nil
+	})
+	flag.Uint64Var(&MaxWriters, "max-writers", MaxWriters, "max number of the op channels and their writer goroutines, the partitions beyond it share them")
+	flag.Func("collision", "policy of off/overwrite/reject/log when a load writes a key written from a different mobile string, default off", func(v string) (err error) {
+		Collisions, err = ParseCollisionPolicy(v)
+		return err
+	})
+	flag.IntVar(&MaxBatchResponseBytes, "max-batch-response", MaxBatchResponseBytes, "max bytes of the results of a batch query, the beyond are omitted, 0 for unlimited")
+	flag.DurationVar(&NeighborsTTL, "neighbors-ttl", NeighborsTTL, "time to live of the cached neighbors results")
+	flag.IntVar(&MaxNeighborScans, "max-neighbor-scans", MaxNeighborScans, "max concurrent full scans of the neighbors queries, the beyond are rejected with 429")
+	flag.DurationVar(&CooccurTTL, "cooccur-ttl", CooccurTTL, "time to live of the cached co-occurrence results")
 	flag.Parse()
 
 	db := &pebbleDB{}
-	if err := db.Open("labelsdb/db", Partitions); err != nil {
+	if err := db.Open(*pDB, Partitions); err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	r := httprouter.New()
-	r.POST("/load/:file/:label", wrapHandler(db.LoadFile))
-	r.GET("/labels/:mobile", wrapHandler(db.GetLabel))
+	if *pMerge != "" {
+		stats, err := db.Merge(strings.Split(*pMerge, ","))
+		if err != nil {
+			logAt(LevelError, "merge failed: %v", err)
+			return
+		}
+		logAt(LevelInfo, "merge complete, keys: %d, merged: %d, dups: %d, conflicts: %d",
+			stats.Keys, stats.Merged, stats.Dups, stats.Conflicts)
+		return
+	}
+
+	logAt(LevelInfo, "Listening on %d", *pPort)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *pPort), newRouter(db)))
+}
 
-	log.Printf("Listening on %d", *pPort)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *pPort), r))
+// newRouter routes the http api to the db.
+func newRouter(db *pebbleDB) *httprouter.Router {
+	r := httprouter.New()
+	r.POST("/load/:file/:label", wrapHandler(db.backpressure(db.LoadFile)))
+	r.GET("/labels/:mobile", wrapHandler(db.backpressure(db.GetLabel)))
+	r.POST("/labels", wrapHandler(db.backpressure(db.GetLabels)))
+	r.DELETE("/labels/:mobile", wrapHandler(db.PurgeLabel))
+	r.DELETE("/labels/:mobile/:label", wrapHandler(db.RemoveLabel))
+	r.PUT("/labels/:mobile/:label", wrapHandler(db.backpressure(db.PutLabel)))
+	r.GET("/labels/:mobile/cooccur", wrapHandler(db.Cooccur))
+	r.GET("/labels/:mobile/neighbors", wrapHandler(db.Neighbors))
+	r.POST("/reconcile", wrapHandler(db.Reconcile))
+	r.GET("/mobiles/:label", wrapHandler(db.CountMobiles))
+	r.GET("/mobiles/:label/sample", wrapHandler(db.SampleMobiles))
+	r.GET("/stream/:label", db.LoadStream)
+	r.GET("/export", wrapHandler(db.Export))
+	r.GET("/follows", wrapHandler(db.Follows))
+	r.DELETE("/follows/:id", wrapHandler(db.CancelFollow))
+	r.GET("/readyz", wrapHandler(db.Readyz))
+	r.GET("/stats", wrapHandler(db.Stats))
+	r.GET("/debug/chunks", wrapHandler(DebugChunks))
+	r.POST("/admin/write", wrapHandler(adminOnly(db.AdminWrite)))
+	r.DELETE("/admin/loads/incomplete", wrapHandler(adminOnly(db.ClearIncompleteLoads)))
+	r.POST("/admin/loglevel", wrapHandler(adminOnly(AdminLogLevel)))
+	r.GET("/admin/compact/estimate", wrapHandler(adminOnly(db.CompactEstimate)))
+	return r
 }
 
 func wrapHandler(h func(http.ResponseWriter, *http.Request, httprouter.Params) error) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		w = rec
+		defer func() {
+			if e := recover(); e != nil {
+				logAt(LevelError, "panic in %s %s: %v\n%s", r.Method, r.URL.Path, e, debug.Stack())
+				jsonResponseError(w, &StatusError{Code: http.StatusInternalServerError, Err: fmt.Errorf("internal error: %v", e)})
+			}
+			accessLog(rec, r, p, start)
+		}()
+
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if IsBool(r.URL.Query().Get("pretty")) {
+			w = &prettyResponseWriter{ResponseWriter: w}
+		}
 		if err := h(w, r, p); err != nil {
 			jsonResponseError(w, err)
 		}
@@ -53,33 +164,68 @@ func wrapHandler(h func(http.ResponseWriter, *http.Request, httprouter.Params) e
 // H is alias for map[string]any.
 type H map[string]any
 
+// Pretty indents the JSON responses for human consumption, compact by default for production throughput.
+var Pretty bool
+
+// prettyResponseWriter marks the response to be indented, by the query param pretty=true.
+type prettyResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Flush flushes the underlying writer if it supports, for the streaming responses.
+func (w *prettyResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func newJSONEncoder(w http.ResponseWriter) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if _, ok := w.(*prettyResponseWriter); ok || Pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
+
 func jsonResponse(w http.ResponseWriter, body H) error {
-	if err := json.NewEncoder(w).Encode(H{"body": body, "status": "ok"}); err != nil {
-		log.Printf("encode json response failed: %v", err)
+	if err := newJSONEncoder(w).Encode(H{"body": body, "status": "ok"}); err != nil {
+		logAt(LevelError, "encode json response failed: %v", err)
 	}
 	return nil
 }
 
+// StatusError is an error with the http status code to respond.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *StatusError) Error() string { return e.Err.Error() }
+func (e *StatusError) Unwrap() error { return e.Err }
+
 func jsonResponseError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusBadRequest)
+	code := http.StatusBadRequest
+	var se *StatusError
+	if errors.As(err, &se) {
+		code = se.Code
+	}
+	w.WriteHeader(code)
 
-	if err := json.NewEncoder(w).Encode(H{"status": "error", "error": err.Error()}); err != nil {
-		log.Printf("encode json response failed: %v", err)
+	if err := newJSONEncoder(w).Encode(H{"status": "error", "error": err.Error()}); err != nil {
+		logAt(LevelError, "encode json response failed: %v", err)
 	}
 }
 
-func scanFilePart(file string, wg *sync.WaitGroup, lineCallback func(line string) error, start, end int, chop *Chop) error {
-	defer wg.Done()
-
+func scanFilePart(file string, lineCallback func(line string) error, start, end int, chop *Chop) error {
 	f, err := os.OpenFile(file, os.O_RDONLY, os.ModePerm)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer f.Close()
 
 	if start > 0 {
 		if _, err := f.Seek(int64(start), io.SeekStart); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
@@ -88,13 +234,16 @@ func scanFilePart(file string, wg *sync.WaitGroup, lineCallback func(line string
 	const bufferSize = 16 * 1024
 	buffer := make([]byte, bufferSize)
 	lines := 0
+	newlines := 0    // the line number is only known in the first part, which starts from the beginning of the file.
+	pos := start     // the offset of the current byte in the file
+	lineOffset := -1 // the offset of the current line in the file
 	lineStarted := false
 	for total := 0; total < countBytes; {
 		n, err := f.Read(buffer)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		total += n
@@ -106,6 +255,7 @@ func scanFilePart(file string, wg *sync.WaitGroup, lineCallback func(line string
 		for _, b := range bb {
 			if IsSpace(b) {
 				if b == '\n' {
+					newlines++
 					chop.linebreak = true
 					if !lineStarted {
 						lineStarted = true
@@ -114,19 +264,28 @@ func scanFilePart(file string, wg *sync.WaitGroup, lineCallback func(line string
 					if len(line) > 0 {
 						lines++
 						if err := lineCallback(strings.TrimSpace(string(line))); err != nil {
-							return err
+							lineNo := 0
+							if start == 0 {
+								lineNo = newlines
+							}
+							return annotateLineError(err, file, lineNo, lineOffset)
 						}
 						line = line[:0]
 					}
 				}
 			} else if lineStarted {
+				if len(line) == 0 {
+					lineOffset = pos
+				}
 				line = append(line, b)
 			} else {
 				chop.head = append(chop.head, b)
 			}
+			pos++
 		}
 	}
 	chop.tail = append(chop.tail, line...)
+	chop.tailOffset = lineOffset
 	return nil
 }
 
@@ -140,96 +299,358 @@ func IsSpace(b byte) bool {
 }
 
 type Chop struct {
-	head      []byte
-	tail      []byte
-	linebreak bool
+	head       []byte
+	tail       []byte
+	tailOffset int // the offset of the tail in the file
+	linebreak  bool
 }
 
-func scanFile(file string, syncMode bool, lineCallback func(line string) error) error {
+// Chunk is the byte range [Start, End) of the file scanned by a worker.
+type Chunk struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// chunkRanges splits the file into the byte ranges of the workers, the last one takes the remainder.
+// The number of the workers is limited by the file size, so that every worker scans at least one byte.
+func chunkRanges(fileSize, numWorkers int) []Chunk {
+	if numWorkers > fileSize {
+		numWorkers = fileSize
+	}
+	if numWorkers <= 0 {
+		return nil
+	}
+
+	workerSize := fileSize / numWorkers
+	chunks := make([]Chunk, numWorkers)
+	for i := range chunks {
+		chunks[i] = Chunk{Start: i * workerSize, End: (i + 1) * workerSize}
+	}
+	chunks[numWorkers-1].End = fileSize
+	return chunks
+}
+
+// ScanWorkers is the default number of the workers to scan a file concurrently, 0 for the number of CPUs.
+var ScanWorkers = 0
+
+// MaxGoroutines is the goroutine count of the process above which the loads scan the files sequentially,
+// instead of spawning more workers, 0 to disable.
+var MaxGoroutines = 0
+
+// goroutinesExceeded tells whether the goroutine count of the process exceeds MaxGoroutines.
+func goroutinesExceeded() bool {
+	return MaxGoroutines > 0 && runtime.NumGoroutine() > MaxGoroutines
+}
+
+// MaxScanWorkersFactor limits the workers param of a request to this factor of the default number of the workers.
+const MaxScanWorkersFactor = 4
+
+// parseWorkers parses the workers param of a request, 0 for the default, the ones beyond the limit are rejected,
+// because each worker opens the file in its own goroutine.
+func parseWorkers(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, &StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("bad workers %q: %w", v, err)}
+	}
+	if max := scanWorkers(0) * MaxScanWorkersFactor; n > max {
+		return 0, &StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("workers %d exceed the limit %d", n, max)}
+	}
+	return n, nil
+}
+
+// scanWorkers returns the number of the workers to scan a file, defaults to ScanWorkers or the number of CPUs.
+func scanWorkers(numWorkers int) int {
+	if numWorkers <= 0 {
+		if numWorkers = ScanWorkers; numWorkers <= 0 {
+			numWorkers = runtime.NumCPU()
+		}
+	}
+	return numWorkers
+}
+
+func scanFile(file string, numWorkers int, syncMode bool, lineCallback func(line string) error) error {
+	if strings.HasSuffix(file, ".gz") {
+		return scanGzipFile(file, lineCallback)
+	}
+
 	stat, err := os.Stat(file)
 	if err != nil {
 		return err
 	}
 
-	numWorkers := runtime.NumCPU()
-	fileSize := int(stat.Size())
-	workerSize := fileSize / numWorkers
+	chunks := chunkRanges(int(stat.Size()), scanWorkers(numWorkers))
 	var wg sync.WaitGroup
 
-	chops := make([]*Chop, numWorkers)
-
-	for i := 0; i < numWorkers; i++ {
-		start := i * workerSize
-		end := start + workerSize
-		if end > fileSize {
-			end = fileSize
-		}
+	chops := make([]*Chop, len(chunks))
+	errs := make([]error, len(chunks))
 
+	for i, c := range chunks {
 		chops[i] = &Chop{}
-		wg.Add(1)
 		if !syncMode {
-			go func(c *Chop, start, end int) {
-				if err := scanFilePart(file, &wg, lineCallback, start, end, c); err != nil {
-					log.Fatal(err)
-				}
-			}(chops[i], start, end)
-		} else if err := scanFilePart(file, &wg, lineCallback, start, end, chops[i]); err != nil {
+			wg.Add(1)
+			go func(i, start, end int) {
+				// done after the error is set, so that it is visible after wg.Wait.
+				defer wg.Done()
+				errs[i] = scanFilePart(file, lineCallback, start, end, chops[i])
+			}(i, c.Start, c.End)
+		} else if err := scanFilePart(file, lineCallback, c.Start, c.End, chops[i]); err != nil {
 			return err
 		}
 	}
 
 	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 
+	return joinChops(file, chunks, chops, lineCallback)
+}
+
+// joinChops reassembles the lines crossing the boundaries of the chunks.
+// Such a line starts at the tail of a chop, continues through the heads of any number of the following
+// chops without linebreak (which are wholly inside the line), and ends at the head of the first chop
+// with a linebreak, or at the end of the file.
+func joinChops(file string, chunks []Chunk, chops []*Chop, lineCallback func(line string) error) error {
 	var line []byte
+	lineOffset := 0
 
-	for i := 0; i < numWorkers; i++ {
-		chop := chops[i]
+	for i, chop := range chops {
+		if len(line) == 0 {
+			lineOffset = chunks[i].Start
+		}
 		line = append(line, chop.head...)
-		if chop.linebreak {
-			if len(line) > 0 {
-				if err := lineCallback(string(line)); err != nil {
-					return err
-				}
-				line = line[:0]
+		if !chop.linebreak {
+			continue // the line continues to the next chop.
+		}
+
+		if len(line) > 0 {
+			if err := lineCallback(string(line)); err != nil {
+				return annotateLineError(err, file, 0, lineOffset)
 			}
+			line = line[:0]
 		}
+		lineOffset = chop.tailOffset
 		line = append(line, chop.tail...)
 	}
 	if len(line) > 0 {
 		if err := lineCallback(string(line)); err != nil {
-			return err
+			return annotateLineError(err, file, 0, lineOffset)
 		}
 	}
 
 	return nil
 }
 
+// scanGzipFile scans the gzip file sequentially, because the compressed stream can not be split to workers.
+// All the concatenated gzip members are read until EOF, not only the first one.
+func scanGzipFile(file string, lineCallback func(line string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	zr.Multistream(true)
+	return scanReader(file, zr, lineCallback)
+}
+
+// scanFileSequentially scans the file, maybe gzipped, line by line by a single reader,
+// which trims the spaces at the ends of the lines only.
+func scanFileSequentially(file string, lineCallback func(line string) error) error {
+	if strings.HasSuffix(file, ".gz") {
+		return scanGzipFile(file, lineCallback)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return scanReader(file, f, lineCallback)
+}
+
+// isTarFile tells whether the file is a tar archive by its name, maybe gzipped as .tar.gz or .tgz.
+// scanSequentially tells whether the file is scanned by the loads sequentially by a single reader, instead of
+// by the chunks of the workers: the gzip files and the tar archives are not splittable, and the chunk scanner
+// drops the spaces inside the lines, which are significant in the JSON string values of the ndjson records.
+func scanSequentially(file string, ndjson bool) bool {
+	return strings.HasSuffix(file, ".gz") || isTarFile(file) || ndjson
+}
+
+func isTarFile(file string) bool {
+	return strings.HasSuffix(file, ".tar") || strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz")
+}
+
+// scanTarFile scans the regular files in the tar archive sequentially, streaming the archive without extracting it.
+// The other entries, like the dirs and the links, are skipped. entryStart is called with the name of each entry before its lines.
+func scanTarFile(file string, entryStart func(name string), lineCallback func(line string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(file, ".tar") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entryStart(h.Name)
+		if err := scanReader(file+"/"+h.Name, tr, lineCallback); err != nil {
+			return err
+		}
+	}
+}
+
+// entryLabel derives the label from the name of the tar entry, the base name without the extensions,
+// e.g. south/gd.txt to gd.
+func entryLabel(name string) string {
+	base := filepath.Base(name)
+	if i := strings.IndexByte(base, '.'); i > 0 {
+		base = base[:i]
+	}
+	return NormalizeLabel(base)
+}
+
+// CommentPrefix is the default prefix of the comment lines skipped by the loads, empty to disable.
+var CommentPrefix = ""
+
+// skipLine tells whether the line should be skipped silently instead of parsed, i.e. an empty line or a comment.
+// The scanners already drop the blank lines, and the spaces in the lines, e.g. "# comment" is seen as "#comment".
+func skipLine(line, commentPrefix string) bool {
+	return line == "" || commentPrefix != "" && strings.HasPrefix(line, commentPrefix)
+}
+
+// scanReader scans the reader line by line, blank lines are ignored.
+// The file name is only used to annotate the errors.
+func scanReader(file string, r io.Reader, lineCallback func(line string) error) error {
+	br := bufio.NewReaderSize(r, 16*1024)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadString('\n')
+		if l := strings.TrimSpace(line); l != "" {
+			if err := lineCallback(l); err != nil {
+				return annotateLineError(err, file, lineNo, -1)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// HashSeed is the seed of the xxhash for the partitioning, to shard identically to a peer system.
+// The unseeded xxhash is the same as seed 0.
+var HashSeed uint64
+
+// hashSeedSet tells whether the HashSeed is set explicitly, or adopts the one persisted in the meta.
+var hashSeedSet bool
+
 func Hash(data []byte) uint64 {
-	h := xxhash.New()
+	h := xxhash.NewWithSeed(HashSeed)
 	h.Write(data)
 	return h.Sum64()
 }
 
 type pebbleDB struct {
-	dbs []*pebble.DB // Primary data
-	dbc []chan op
-	sync.WaitGroup
+	path     string
+	readonly bool
+	dbs      []*pebble.DB // Primary data
+	dbc      []chan op
+	writers  sync.WaitGroup // the writer goroutines consuming the op channels
+
+	seqs      []uint64 // write sequences of the partitions, see WriteSeq
+	iterSlots iteratorSlots
+	disks     []*disk
+	quotas    quotaTrackers
+	throttle  ingestThrottle
+	follows   followers
+	meta      metaStore
+
+	// the cached results of the full scans, the concurrent scans of the neighbors are limited by neighborScans.
+	cooccurs       ttlCache[*cooccurResult]
+	neighborsCache ttlCache[*neighborsResult]
+	neighborScans  chan struct{}
+
+	// hll estimates the distinct mobiles written, it is persisted in the meta, the purged mobiles are not removed from it.
+	hll HyperLogLog
+
+	closing chan struct{}  // closed when the db is closing, to stop the background jobs
+	jobs    sync.WaitGroup // background jobs
 }
 
 func (s *pebbleDB) GetLabel(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
 	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
 	mobile, err := mobile2bytes(p.ByName("mobile"))
 	if err != nil {
 		return err
 	}
 
-	labels, err := s.FindLabelsByMobile(mobile)
+	withSource, withBatch := IsBool(r.URL.Query().Get("with_source")), IsBool(r.URL.Query().Get("with_batch"))
+	withSeq := IsBool(r.URL.Query().Get("with_seq"))
+	if withSource || withBatch || withSeq {
+		entries, err := s.FindLabelEntriesByMobile(ds.key(mobile))
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if !withSource {
+				entries[i].Source = ""
+			}
+			if !withBatch {
+				entries[i].Batch = ""
+			}
+			if !withSeq {
+				entries[i].Seq = 0
+			}
+		}
+
+		cost := time.Since(start)
+		return jsonResponse(w, H{"cost": cost.String(), "mobile": bytes2mobile(mobile), "labels": entries})
+	}
+
+	labels, err := s.FindLabelsByMobile(ds.key(mobile))
 	if err != nil {
 		return err
 	}
 
 	cost := time.Since(start)
-	return jsonResponse(w, H{"cost": cost.String(), "labels": labels})
+	logAt(LevelDebug, "get labels of mobile %s in partition %d: %v, cost %s", bytes2mobile(mobile), s.Partition(ds.key(mobile)), labels, cost)
+	return jsonResponse(w, H{"cost": cost.String(), "mobile": bytes2mobile(mobile), "labels": labels})
 }
 
 func IsBool(s string) bool {
@@ -247,113 +668,423 @@ func FoldAnyOf(t string, bb ...string) bool {
 }
 
 func (s *pebbleDB) LoadFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 	file := p.ByName("file")
-	label := p.ByName("label")
-	noop := IsBool(r.URL.Query().Get("noop"))
+	label := NormalizeLabel(p.ByName("label"))
+	l, err := s.newLineLoader(r, label, file)
+	if err != nil {
+		return err
+	}
+	l.noop = IsBool(r.URL.Query().Get("noop"))
 	syncMode := IsBool(r.URL.Query().Get("sync"))
-	log.Printf("start to load file %s", file)
+	// warnings are the non-fatal issues of the load, reported in the response.
+	var warnings []string
+	if !syncMode && goroutinesExceeded() {
+		warning := fmt.Sprintf("goroutines %d exceed %d, load file sequentially", runtime.NumGoroutine(), MaxGoroutines)
+		logAt(LevelWarn, "%s: %s", file, warning)
+		warnings = append(warnings, warning)
+		syncMode = true
+	}
+	workers, err := parseWorkers(r.URL.Query().Get("workers"))
+	if err != nil {
+		return err
+	}
+	durable := IsBool(r.URL.Query().Get("durable"))
+	if IsBool(r.URL.Query().Get("bulk")) {
+		l.bulk = s.newBulkLoader()
+		defer l.bulk.cleanup()
+	}
+	logAt(LevelInfo, "start to load file %s", file)
 	start := time.Now()
-	var lines atomic.Uint64
-	if err := scanFile(file, syncMode, func(line string) error {
-		lines.Add(1)
-		if !noop {
-			mobile, err := mobile2bytes(line)
-			if err != nil {
-				return err
+	entryLabels := IsBool(r.URL.Query().Get("entry_label"))
+	lineCallback := l.callback()
+
+	if IsBool(r.URL.Query().Get("follow")) {
+		if l.bulk != nil || l.noop || isTarFile(file) || strings.HasSuffix(file, ".gz") {
+			return fmt.Errorf("follow mode does not support bulk, noop, gzip files or tar archives")
+		}
+		f, err := s.follow(file, label, lineCallback, func() error {
+			return multierr.Append(l.saveQuotas(), s.meta.saveHLL(&s.hll))
+		})
+		if err != nil {
+			return err
+		}
+		cost := time.Since(start)
+		logAt(LevelInfo, "follow file: %s with label: %s as %s, lines: %d, cost %s", file, label, f.ID, l.lines.Load(), cost)
+		return jsonResponse(w, H{"cost": cost.String(), "follow": f.ID, "lines": l.lines.Load(), "parsed": l.lines.Load(),
+			"written": l.written.Load(), "skipped": l.skipped.Load(), "rejected": l.rejected.Load()})
+	}
+
+	var loadKey string
+	stopProgress := func() {}
+	if !l.noop {
+		if loadKey, err = s.meta.beginLoad(file, label); err != nil {
+			return err
+		}
+		// the scanned lines of the bulk load are not committed until ingested.
+		if l.bulk == nil {
+			stopProgress = s.trackLoadProgress(loadKey, l.committed.Load)
+		}
+	}
+	switch {
+	case isTarFile(file):
+		err = scanTarFile(file, func(name string) {
+			if entryLabels {
+				l.lineLabel = entryLabel(name)
 			}
-			s.Append(mobile, []byte(label))
-			return nil
+		}, lineCallback)
+	case scanSequentially(file, l.ndjson != nil):
+		err = scanFileSequentially(file, lineCallback)
+	default:
+		err = scanFile(file, workers, syncMode, lineCallback)
+	}
+	if err == nil && l.bulk != nil {
+		var keys int
+		keys, err = s.ingest(l.bulk)
+		logAt(LevelInfo, "bulk ingested %d keys", keys)
+	}
+	if err == nil && durable {
+		err = s.Sync(l.touchedPartitions())
+	}
+	stopProgress()
+	if !l.noop {
+		err = multierr.Append(err, l.saveQuotas())
+		err = multierr.Append(err, s.meta.saveHLL(&s.hll))
+		var n uint64
+		if err != nil && l.bulk == nil {
+			l.barrier()
+			n = l.committed.Load()
 		}
-		return nil
-	}); err != nil {
+		err = multierr.Append(err, s.meta.endLoad(loadKey, err, l.written.Load(), n))
+	}
+	if err != nil {
 		return err
 	}
 	cost := time.Since(start)
-	log.Printf("load file: %s with label: %s, lines: %d, sync: %t complete, cost %s", file, label, lines.Load(), syncMode, cost)
-	return jsonResponse(w, H{"cost": cost.String(), "lines": lines.Load()})
+	lines := l.lines.Load()
+	logAt(LevelInfo, "load file: %s with label: %s, lines: %d, sync: %t complete, cost %s", file, label, lines, syncMode, cost)
+	body := H{"cost": cost.String(), "lines": lines, "parsed": lines, "written": l.written.Load(),
+		"skipped": l.skipped.Load(), "rejected": l.rejected.Load(), "sync": syncMode}
+	if IngestRate.PerSecond > 0 {
+		n := lines
+		if IngestRate.Bytes {
+			n = l.size.Load()
+		}
+		body["ingest_rate"] = H{"limit": IngestRate.String(), "effective": Rate{PerSecond: float64(n) / cost.Seconds(), Bytes: IngestRate.Bytes}.String()}
+	}
+	if Collisions != CollisionOff {
+		body["collisions"] = l.collisions.Load()
+	}
+	if q := l.quotaOf(label); q != nil {
+		body["quota"] = q.report(q.overflow.Load())
+	}
+	warnings = append(warnings, l.warnings()...)
+	if len(warnings) > 0 {
+		body["warnings"] = warnings
+	}
+	return jsonResponse(w, body)
 }
 
 func (s *pebbleDB) FindLabelsByMobile(mobile []byte) (labels []string, err error) {
-	partition := s.Partition(mobile)
-	db := s.dbs[partition]
+	err = s.iterateLabels(mobile, func(label, _ []byte) {
+		labels = append(labels, string(label))
+	})
+	return labels, err
+}
 
-	keyUpperBound := func(b []byte) []byte {
-		end := make([]byte, len(b))
-		copy(end, b)
-		for i := len(end) - 1; i >= 0; i-- {
-			end[i] += 1
-			if end[i] != 0 {
-				return end[:i+1]
-			}
-		}
-		return nil // no upper-bound
-	}
+// FindLabelEntriesByMobile finds the labels of the mobile together with the metadata stored with them.
+func (s *pebbleDB) FindLabelEntriesByMobile(mobile []byte) (entries []LabelEntry, err error) {
+	err = s.iterateLabels(mobile, func(label, value []byte) {
+		v := DecodeLabelValue(value)
+		entries = append(entries, LabelEntry{Label: string(label), Source: v.Source, Batch: v.Batch, Seq: v.Seq})
+	})
+	return entries, err
+}
 
-	prefixIterOptions := func(prefix []byte) *pebble.IterOptions {
-		return &pebble.IterOptions{
-			LowerBound: prefix,
-			UpperBound: keyUpperBound(prefix),
-		}
+func (s *pebbleDB) iterateLabels(mobile []byte, fn func(label, value []byte)) error {
+	return s.iterateLabelsIn(s.Partition(mobile), mobile, fn)
+}
+
+// FindLabelsInPartition finds the labels of the mobile in the given partition, instead of the hashed one.
+func (s *pebbleDB) FindLabelsInPartition(partition uint64, mobile []byte) (labels []string, err error) {
+	err = s.iterateLabelsIn(partition, mobile, func(label, _ []byte) {
+		labels = append(labels, string(label))
+	})
+	return labels, err
+}
+
+func (s *pebbleDB) iterateLabelsIn(partition uint64, mobile []byte, fn func(label, value []byte)) (err error) {
+	release, err := s.iterSlots.acquire(partition)
+	if err != nil {
+		return err
 	}
+	defer release()
+
+	db := s.dbs[partition]
 	iter := db.NewIter(prefixIterOptions(mobile))
+	// close the iterator even on panic, otherwise it leaks and pins the resources.
+	defer func() {
+		if closeErr := iter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
 	for iter.First(); iter.Valid(); iter.Next() {
 		key := iter.Key()
-		labels = append(labels, string(key[len(mobile):]))
+		fn(key[len(mobile):], iter.Value())
 	}
-	if err := iter.Close(); err != nil {
-		return nil, err
+	return nil
+}
+
+func keyUpperBound(b []byte) []byte {
+	end := make([]byte, len(b))
+	copy(end, b)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i] += 1
+		if end[i] != 0 {
+			return end[:i+1]
+		}
 	}
+	return nil // no upper-bound
+}
 
-	return labels, err
+func prefixIterOptions(prefix []byte) *pebble.IterOptions {
+	return &pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: keyUpperBound(prefix),
+	}
 }
 
-func (s *pebbleDB) Append(key, value []byte) {
-	partition := s.Partition(key)
-	s.dbc[partition] <- op{
-		typ:   opSet,
-		key:   append(key, value...),
-		value: []byte{},
+// PutLabel sets the label to the mobile, only if the mobile does not have the label yet with ifabsent=true.
+func (s *pebbleDB) PutLabel(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	mobile, err := mobile2bytes(p.ByName("mobile"))
+	if err != nil {
+		return err
+	}
+	label := NormalizeLabel(p.ByName("label"))
+
+	quota := s.quotaTracker(label)
+	if quota != nil && !quota.admit(s, ds.key(mobile), []byte(label), nil) {
+		return errQuotaExceeded(label)
+	}
+	written := true
+	if IsBool(r.URL.Query().Get("ifabsent")) {
+		if written, err = s.SetIfAbsent(ds.key(mobile), []byte(label), []byte{}); err != nil {
+			return err
+		}
+	} else if err := s.Add(ds.key(mobile), []byte(label), nil); err != nil {
+		return err
+	}
+	if quota != nil {
+		if err := quota.save(&s.meta); err != nil {
+			return err
+		}
 	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "mobile": bytes2mobile(mobile), "label": label, "written": written})
 }
 
-func (s *pebbleDB) Get(key []byte) (values []string, err error) {
-	partition := s.Partition(key)
-	value, closer, err := s.dbs[partition].Get(key)
-	if closer != nil {
-		defer closer.Close()
+// PurgeLabel deletes all the labels of the mobile.
+func (s *pebbleDB) PurgeLabel(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
 	}
+	mobile, err := mobile2bytes(p.ByName("mobile"))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err == pebble.ErrNotFound {
-		return nil, nil
+	removed, err := s.Purge(ds.key(mobile))
+	if err != nil {
+		return err
 	}
 
-	for _, l := range bytes.Split(value, []byte(",")) {
-		values = append(values, string(l))
+	cost := time.Since(start)
+	logAt(LevelInfo, "purge mobile: %s, removed labels: %d, cost %s", p.ByName("mobile"), removed, cost)
+	return jsonResponse(w, H{"cost": cost.String(), "removed": removed})
+}
+
+// Purge deletes all the keys with the mobile prefix by a ranged delete, returns the number of the removed labels.
+// The ranged delete is queued in the op channel of the partition, so it applies after the writes queued before it.
+func (s *pebbleDB) Purge(mobile []byte) (removed int, err error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	partition := s.Partition(mobile)
+	s.barrierPartition(partition)
+	var labels, values [][]byte
+	if err := s.iterateLabelsIn(partition, mobile, func(label, value []byte) {
+		labels = append(labels, append([]byte{}, label...))
+		values = append(values, append([]byte{}, value...))
+	}); err != nil {
+		return 0, err
+	}
+	if len(labels) == 0 {
+		return 0, nil
 	}
 
-	return values, nil
+	end := keyUpperBound(mobile)
+	if end == nil {
+		// the mobile key of all 0xff bytes, e.g. 18446744073709551615, has no upper bound for the ranged delete,
+		// so its labels are deleted one by one, counting only the ones actually removed.
+		for _, label := range labels {
+			ok, err := s.Remove(mobile, label)
+			if err != nil {
+				return removed, err
+			}
+			if ok {
+				removed++
+			}
+		}
+		return removed, nil
+	}
+
+	done := make(chan struct{})
+	s.opc(partition) <- op{typ: opDeleteRange, partition: partition, key: mobile, value: end, done: done}
+	<-done
+	for i, label := range labels {
+		if err := s.releaseQuota(mobile, label, values[i]); err != nil {
+			return len(labels), err
+		}
+	}
+	return len(labels), nil
 }
 
-// Set implements DB
-func (s *pebbleDB) Set(key, value []byte) {
+// Append adds the label to the mobile, it is the same as Add without value.
+func (s *pebbleDB) Append(mobile, label []byte) error {
+	return s.Add(mobile, label, nil)
+}
+
+// AppendWithValue appends the label to the key, storing value (maybe nil) alongside it.
+func (s *pebbleDB) AppendWithValue(key, label, value []byte) error {
+	if s.readonly {
+		return ErrReadOnly
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	s.hll.Add(key)
 	partition := s.Partition(key)
-	s.dbc[partition] <- op{
-		typ:   opSet,
-		key:   key,
-		value: value,
+	s.opc(partition) <- op{
+		typ:       opSet,
+		partition: partition,
+		key:       append(key, label...),
+		value:     value,
+		stamp:     WriteSeq,
+	}
+	return nil
+}
+
+// Set implements DB
+func (s *pebbleDB) Set(key, value []byte) error {
+	return s.SetPartition(s.Partition(key), key, value)
+}
+
+// SetPartition sets the key to the given partition, bypassing the hash partitioning.
+func (s *pebbleDB) SetPartition(partition uint64, key, value []byte) error {
+	if s.readonly {
+		return ErrReadOnly
+	}
+	s.opc(partition) <- op{
+		typ:       opSet,
+		partition: partition,
+		key:       key,
+		value:     value,
+	}
+	return nil
+}
+
+// SetIfAbsent sets the mobile+label key only if it is absent, returns whether the key is written.
+func (s *pebbleDB) SetIfAbsent(mobile, label, value []byte) (bool, error) {
+	if s.readonly {
+		return false, ErrReadOnly
+	}
+	partition := s.Partition(mobile)
+	var applied bool
+	done := make(chan struct{})
+	key := append(append([]byte{}, mobile...), label...)
+	s.opc(partition) <- op{typ: opSetIfAbsent, partition: partition, key: key, value: value, done: done, applied: &applied, stamp: WriteSeq}
+	<-done
+	if applied {
+		s.hll.Add(mobile)
+	}
+	return applied, nil
+}
+
+// ReadOnly opens the db in read-only mode, without the op channels and their goroutines, for the query only replicas.
+var ReadOnly bool
+
+// ErrReadOnly is the error of writing to the db opened in read-only mode.
+var ErrReadOnly = errors.New("db is opened in read-only mode")
+
+// checkWritable returns a 403 error if the db is opened in read-only mode.
+func (s *pebbleDB) checkWritable() error {
+	if s.readonly {
+		return &StatusError{Code: http.StatusForbidden, Err: ErrReadOnly}
+	}
+	return nil
+}
+
+// Barrier blocks until all the ops queued before it in every partition (op channel) are applied.
+func (s *pebbleDB) Barrier() {
+	dones := make([]chan struct{}, len(s.dbc))
+	for i, c := range s.dbc {
+		dones[i] = make(chan struct{})
+		c <- op{typ: opBarrier, done: dones[i]}
+	}
+	for _, done := range dones {
+		<-done
+	}
+}
+
+// Sync makes the data written to the partitions durable, by applying their queued ops,
+// flushing the memtables and fsyncing the WALs.
+func (s *pebbleDB) Sync(partitions []uint64) error {
+	for _, p := range partitions {
+		s.barrierPartition(p)
+		db := s.dbs[p]
+		if err := db.Flush(); err != nil {
+			return err
+		}
+		if err := db.LogData(nil, pebble.Sync); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (s *pebbleDB) barrierPartition(partition uint64) {
+	done := make(chan struct{})
+	s.opc(partition) <- op{typ: opBarrier, partition: partition, done: done}
+	<-done
 }
 
 // Close implements DB
 func (s *pebbleDB) Close() (err error) {
+	close(s.closing)
+	s.jobs.Wait()
+
 	for _, db := range s.dbc {
 		close(db)
 	}
-	s.Wait()
+	s.writers.Wait()
 
+	if !s.readonly {
+		err = s.meta.saveHLL(&s.hll)
+	}
 	for _, db := range s.dbs {
 		err = multierr.Append(err, db.Close())
 	}
@@ -365,69 +1096,160 @@ type opType uint32
 const (
 	_ opType = iota
 	opSet
-	opAppend
+	opBarrier
+	opDeleteRange // delete the range [key, value)
+	opSetIfAbsent // set only if the key is absent, reporting by applied
+	opDelete      // delete the key, reporting whether it existed by applied
 )
 
+var errClosing = errors.New("db is closing")
+
 type op struct {
 	typ        opType
+	partition  uint64
 	key, value []byte
+	done       chan struct{}
+	applied    *bool // whether the conditional op is applied, valid after done is closed
+	stamp      bool  // stamp the value with the write sequence, see WriteSeq
+}
+
+// opc returns the op channel of the partition, shared by the partitions beyond MaxWriters.
+func (s *pebbleDB) opc(partition uint64) chan op {
+	return s.dbc[partition%uint64(len(s.dbc))]
 }
 
 // Open implements DB
 func (s *pebbleDB) Open(path string, partitions uint64) (err error) {
+	s.path = path
+	s.readonly = ReadOnly
+	s.closing = make(chan struct{})
+	s.meta.readonly = s.readonly
+	if err := s.meta.open(path); err != nil {
+		return err
+	}
+	if err := s.meta.checkPartitioning(); err != nil {
+		return err
+	}
+	if err := s.hll.UnmarshalBinary(s.meta.HLL); err != nil {
+		return err
+	}
+	if loads := s.meta.incompleteLoads(); len(loads) > 0 {
+		logAt(LevelWarn, "found %d incomplete loads, not ready until they are reloaded completely or cleared by DELETE /admin/loads/incomplete", len(loads))
+	}
+
+	if partitions > MaxPartitions {
+		return fmt.Errorf("%d partitions exceed the sanity cap %d", partitions, MaxPartitions)
+	}
+	s.neighborScans = make(chan struct{}, MaxNeighborScans)
 	s.dbs = make([]*pebble.DB, partitions)
-	s.dbc = make([]chan op, partitions)
+	s.iterSlots = newIteratorSlots(partitions)
+	s.disks = newDisks(path, partitions)
 	for i := uint64(0); i < partitions; i++ {
-		name := fmt.Sprintf("%s.%d", path, i)
-		s.dbs[i], err = pebble.Open(name, &pebble.Options{})
+		name := partitionDir(path, i)
+		s.dbs[i], err = pebble.Open(name, &pebble.Options{ReadOnly: s.readonly})
 		if err != nil {
 			return err
 		}
+	}
+	if s.readonly {
+		return nil
+	}
 
+	writers := partitions
+	if writers > MaxWriters {
+		writers = affinityWriters(MaxWriters, len(s.disks))
+		logAt(LevelWarn, "%d partitions exceed %d writers, the partitions share the op channels and the writer goroutines", partitions, writers)
+	}
+	s.seqs = newWriteSeqs(partitions)
+	s.dbc = make([]chan op, writers)
+	for i := range s.dbc {
 		s.dbc[i] = make(chan op, 10000)
-		s.Add(1)
-		go func(db *pebble.DB, c chan op) {
-			defer s.Done()
-
-			for k := range c {
-				switch k.typ {
-				case opSet:
-					if err := db.Set(k.key, k.value, pebble.NoSync); err != nil {
-						log.Fatal(err)
-					}
-				case opAppend:
-					v, closer, err := db.Get(k.key)
-					if err == pebble.ErrNotFound {
-						err = nil
-					}
-					if err != nil {
-						log.Fatal(err)
-					}
-					if len(v) > 0 {
-						k.value = append(k.value, ',')
-						k.value = append(k.value, v...)
-					}
-					if closer != nil {
-						closer.Close()
-					}
+		s.writers.Add(1)
+		go s.consume(s.dbc[i])
+	}
+	return nil
+}
 
-					if err := db.Set(k.key, k.value, pebble.NoSync); err != nil {
-						log.Fatal(err)
-					}
+// consume applies the ops in the op channel to their partitions in order.
+func (s *pebbleDB) consume(c chan op) {
+	defer s.writers.Done()
+
+	for k := range c {
+		db := s.dbs[k.partition]
+		switch k.typ {
+		case opSet:
+			if err := db.Set(k.key, s.stampSeq(k), pebble.NoSync); err != nil {
+				log.Fatal(err)
+			}
+		case opDeleteRange:
+			if err := db.DeleteRange(k.key, k.value, pebble.NoSync); err != nil {
+				log.Fatal(err)
+			}
+			close(k.done)
+		case opSetIfAbsent:
+			// the Get-then-Set is race-free, because the ops of a partition are applied by this single goroutine.
+			_, closer, err := db.Get(k.key)
+			if err == nil {
+				closer.Close()
+			} else if err == pebble.ErrNotFound {
+				if err := db.Set(k.key, s.stampSeq(k), pebble.NoSync); err != nil {
+					log.Fatal(err)
+				}
+				*k.applied = true
+			} else {
+				log.Fatal(err)
+			}
+			close(k.done)
+		case opDelete:
+			_, closer, err := db.Get(k.key)
+			if err == nil {
+				closer.Close()
+				if err := db.Delete(k.key, pebble.NoSync); err != nil {
+					log.Fatal(err)
 				}
+				*k.applied = true
+			} else if err != pebble.ErrNotFound {
+				log.Fatal(err)
 			}
-		}(s.dbs[i], s.dbc[i])
+			close(k.done)
+		case opBarrier:
+			close(k.done)
+		}
 	}
-
-	return nil
 }
 
+// Partition returns the partition of the mobile key, partitioned by the mobile without the dataset prefix.
 func (s *pebbleDB) Partition(partitionKey []byte) uint64 {
+	if Datasets {
+		if n := mobileKeyLen(partitionKey); n > 0 {
+			partitionKey = partitionKey[n-mobileLen : n]
+		}
+	}
+	if PartitionPrefix > 0 && len(partitionKey) >= mobileLen {
+		// co-locate the mobiles by the prefix of its decimal form, e.g. the area code.
+		m := strconv.FormatUint(bytes2uint64(partitionKey[:mobileLen]), 10)
+		if len(m) > PartitionPrefix {
+			m = m[:PartitionPrefix]
+		}
+		return Hash([]byte(m)) % Partitions
+	}
+
 	return Hash(partitionKey) % Partitions
 }
 
+// PartitionPrefix is the number of the leading decimal digits of the mobile as the partition key,
+// 0 for the whole mobile, -1 for the one persisted in the meta (or 0 for a new db).
+var PartitionPrefix = -1
+
 var Partitions = uint64(10)
 
+// MaxPartitions is the sanity cap of the partitions, to fail fast instead of exhausting the memory or file handles at Open.
+var MaxPartitions = uint64(16384)
+
+// MaxWriters is the max number of the op channels (with 10000 capacity each) and their writer goroutines,
+// the partitions beyond it share them round-robin, so that a huge partition count does not OOM at Open.
+var MaxWriters = uint64(256)
+
 func init() {
 	if p := os.Getenv("PARTITIONS"); p != "" {
 		if n, err := strconv.Atoi(p); err == nil && n > 0 {
@@ -436,10 +1258,76 @@ func init() {
 	}
 }
 
+// MobileParseError is the error of parsing a bad mobile, with the position of it during loads.
+type MobileParseError struct {
+	Value  string
+	File   string
+	Line   int // line number, 0 if unknown
+	Offset int // byte offset of the line in the file, -1 if unknown
+	Err    error
+}
+
+func (e *MobileParseError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bad mobile %q", e.Value)
+	if e.File != "" {
+		fmt.Fprintf(&b, " in file %s", e.File)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " line %d", e.Line)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&b, " offset %d", e.Offset)
+	}
+	return b.String() + ": " + e.Err.Error()
+}
+
+func (e *MobileParseError) Unwrap() error { return e.Err }
+
+// annotateLineError annotates the MobileParseError in err with the position of the line in the file.
+// The line number is only known by the part of the file starting from the beginning, for the other parts
+// it is counted from the beginning of the file to the offset of the line, which is fine on the error path.
+func annotateLineError(err error, file string, line, offset int) error {
+	var pe *MobileParseError
+	if errors.As(err, &pe) && pe.File == "" {
+		if line == 0 && offset >= 0 {
+			line, _ = lineNumberAt(file, offset)
+		}
+		pe.File, pe.Line, pe.Offset = file, line, offset
+	}
+	return err
+}
+
+// lineNumberAt returns the 1-based line number of the byte offset in the file, by counting the linebreaks before it.
+func lineNumberAt(file string, offset int) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	line := 1
+	br := bufio.NewReaderSize(io.LimitReader(f, int64(offset)), 16*1024)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return line, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if b == '\n' {
+			line++
+		}
+	}
+}
+
 func mobile2bytes(s string) ([]byte, error) {
 	u, err := strconv.ParseUint(s, 10, 64)
 	if err != nil {
-		return nil, err
+		if ne, ok := err.(*strconv.NumError); ok {
+			err = ne.Err
+		}
+		return nil, &MobileParseError{Value: s, Offset: -1, Err: err}
 	}
 
 	b := make([]byte, 8)
@@ -450,3 +1338,17 @@ func mobile2bytes(s string) ([]byte, error) {
 func bytes2uint64(b []byte) uint64 {
 	return binary.LittleEndian.Uint64(b)
 }
+
+// MobileWidth is the fixed width to format mobiles, left padded with zeros, 0 to disable.
+// The mobile is stored as uint64, so leading zeros in the input are lost without it,
+// e.g. 013800138000 is formatted as 13800138000.
+var MobileWidth = 0
+
+// bytes2mobile formats the mobile bytes to its canonical decimal string.
+func bytes2mobile(b []byte) string {
+	s := strconv.FormatUint(bytes2uint64(b), 10)
+	if n := MobileWidth - len(s); n > 0 {
+		s = strings.Repeat("0", n) + s
+	}
+	return s
+}
@@ -0,0 +1,991 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/julienschmidt/httprouter"
+)
+
+// setGlobal sets the global to v for the test, and restores it at the end of the test.
+func setGlobal[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// openTestDB opens a db of the partitions in a temp dir, it is closed at the end of the test.
+// The partitioning globals adopted from the meta by Open are restored at the end of the test too.
+func openTestDB(t *testing.T, partitions uint64) *pebbleDB {
+	t.Helper()
+	return openTestDBAt(t, filepath.Join(t.TempDir(), "db"), partitions)
+}
+
+func openTestDBAt(t *testing.T, path string, partitions uint64) *pebbleDB {
+	t.Helper()
+	setGlobal(t, &PartitionPrefix, PartitionPrefix)
+	setGlobal(t, &HashSeed, HashSeed)
+	setGlobal(t, &hashSeedSet, hashSeedSet)
+	setGlobal(t, &PartitionLayout, PartitionLayout)
+	setGlobal(t, &Datasets, Datasets)
+	// the keys are partitioned by the global Partitions, which main opens the db with.
+	setGlobal(t, &Partitions, partitions)
+
+	db := &pebbleDB{}
+	if err := db.Open(path, partitions); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if !closedTestDBs[db] {
+			db.Close()
+		}
+	})
+	return db
+}
+
+// closedTestDBs are the dbs closed by the tests already, which are not closed again at the end of the tests.
+var closedTestDBs = map[*pebbleDB]bool{}
+
+// closeTestDB closes the db before the end of the test.
+func closeTestDB(t *testing.T, db *pebbleDB) {
+	t.Helper()
+	closedTestDBs[db] = true
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reopenTestDB closes the db and opens it again at the same path.
+func reopenTestDB(t *testing.T, db *pebbleDB) *pebbleDB {
+	t.Helper()
+	closeTestDB(t, db)
+	return openTestDBAt(t, db.path, uint64(len(db.dbs)))
+}
+
+// inTempDir changes the working dir to a temp dir for the test, because the loads take the file names
+// relative to the working dir in the url.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// request serves the request by the router of the db, decodes the body of the ok response into out if not nil,
+// returns the status code and the error message of the error response.
+func request(t *testing.T, db *pebbleDB, method, target, body string, out any) (code int, errMsg string) {
+	t.Helper()
+	return serve(t, db, httptest.NewRequest(method, target, strings.NewReader(body)), out)
+}
+
+// serve is request of the built request, e.g. with the headers.
+func serve(t *testing.T, db *pebbleDB, r *http.Request, out any) (code int, errMsg string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter(db).ServeHTTP(w, r)
+
+	var resp struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Body   json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: bad response %q: %v", r.Method, r.URL, w.Body.String(), err)
+	}
+	if resp.Status == "ok" && out != nil {
+		if err := json.Unmarshal(resp.Body, out); err != nil {
+			t.Fatalf("%s %s: bad response body %s: %v", r.Method, r.URL, resp.Body, err)
+		}
+	}
+	return w.Code, resp.Error
+}
+
+// mustRequest is request expecting the 200 response.
+func mustRequest(t *testing.T, db *pebbleDB, method, target, body string, out any) {
+	t.Helper()
+	if code, errMsg := request(t, db, method, target, body, out); code != http.StatusOK {
+		t.Fatalf("%s %s: status %d, error %s", method, target, code, errMsg)
+	}
+}
+
+// loadResult is the response body of the loads.
+type loadResult struct {
+	Lines    uint64   `json:"lines"`
+	Parsed   uint64   `json:"parsed"`
+	Written  uint64   `json:"written"`
+	Skipped  uint64   `json:"skipped"`
+	Rejected uint64   `json:"rejected"`
+	Warnings []string `json:"warnings"`
+}
+
+// load loads the file with the label by the query, and waits for the writes applied.
+func load(t *testing.T, db *pebbleDB, file, label, query string) loadResult {
+	t.Helper()
+	var res loadResult
+	mustRequest(t, db, http.MethodPost, "/load/"+file+"/"+label+"?"+query, "", &res)
+	db.Barrier()
+	return res
+}
+
+// labelsOf returns the labels of the mobile by the query api.
+func labelsOf(t *testing.T, db *pebbleDB, mobile string) []string {
+	t.Helper()
+	var res struct {
+		Labels []string `json:"labels"`
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/"+mobile, "", &res)
+	return res.Labels
+}
+
+func TestLoadWithSource(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n")
+	writeTestFile(t, "b.txt", "13800000002\n13800000003\n")
+
+	load(t, db, "a.txt", "vip", "with_source=y")
+	load(t, db, "b.txt", "big", "with_source=y")
+
+	var res struct {
+		Labels []LabelEntry `json:"labels"`
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000002?with_source=y", "", &res)
+	want := []LabelEntry{{Label: "big", Source: "b.txt"}, {Label: "vip", Source: "a.txt"}}
+	if len(res.Labels) != len(want) {
+		t.Fatalf("labels %+v, want %+v", res.Labels, want)
+	}
+	for i := range want {
+		if res.Labels[i] != want[i] {
+			t.Errorf("label %d is %+v, want %+v", i, res.Labels[i], want[i])
+		}
+	}
+
+	// the plain query returns the label names only.
+	if got := labelsOf(t, db, "13800000003"); len(got) != 1 || got[0] != "big" {
+		t.Errorf("labels of 13800000003 are %v, want [big]", got)
+	}
+}
+
+func TestLoadMultiMemberGzip(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+
+	var buf bytes.Buffer
+	for _, member := range []string{"13800000001\n13800000002\n", "13800000003\n", "13800000004"} {
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(member)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, "multi.txt.gz", buf.String())
+
+	if res := load(t, db, "multi.txt.gz", "gz", ""); res.Lines != 4 {
+		t.Errorf("loaded %d lines, want 4 of all the members", res.Lines)
+	}
+	for _, m := range []string{"13800000001", "13800000003", "13800000004"} {
+		if got := labelsOf(t, db, m); len(got) != 1 || got[0] != "gz" {
+			t.Errorf("labels of %s are %v, want [gz]", m, got)
+		}
+	}
+}
+
+func TestMobileWidth(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "013800138000\n")
+	load(t, db, "a.txt", "vip", "")
+
+	var res struct {
+		Mobile string   `json:"mobile"`
+		Labels []string `json:"labels"`
+	}
+	// the leading zero is lost by default.
+	mustRequest(t, db, http.MethodGet, "/labels/013800138000", "", &res)
+	if res.Mobile != "13800138000" || len(res.Labels) != 1 {
+		t.Errorf("mobile %s with labels %v, want 13800138000 with [vip]", res.Mobile, res.Labels)
+	}
+
+	setGlobal(t, &MobileWidth, 12)
+	mustRequest(t, db, http.MethodGet, "/labels/013800138000", "", &res)
+	if res.Mobile != "013800138000" {
+		t.Errorf("mobile %s, want 013800138000 of the fixed width", res.Mobile)
+	}
+
+	var sample struct {
+		Mobiles []string `json:"mobiles"`
+	}
+	mustRequest(t, db, http.MethodGet, "/mobiles/vip/sample", "", &sample)
+	if len(sample.Mobiles) != 1 || sample.Mobiles[0] != "013800138000" {
+		t.Errorf("sampled mobiles %v, want [013800138000] of the fixed width", sample.Mobiles)
+	}
+}
+
+func TestPurgeMobile(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n18446744073709551615\n")
+	writeTestFile(t, "b.txt", "13800000001\n18446744073709551615\n")
+	for _, label := range []string{"vip", "big", "new"} {
+		load(t, db, "a.txt", label, "")
+	}
+	load(t, db, "b.txt", "old", "")
+
+	// the max mobile of all 0xff bytes is purged one by one, without the ranged delete.
+	for m, want := range map[string]int{"13800000001": 4, "18446744073709551615": 4} {
+		var res struct {
+			Removed int `json:"removed"`
+		}
+		mustRequest(t, db, http.MethodDelete, "/labels/"+m, "", &res)
+		if res.Removed != want {
+			t.Errorf("purged %d labels of %s, want %d", res.Removed, m, want)
+		}
+		if got := labelsOf(t, db, m); len(got) != 0 {
+			t.Errorf("labels of %s are %v after purged, want none", m, got)
+		}
+	}
+
+	if got := labelsOf(t, db, "13800000002"); len(got) != 3 {
+		t.Errorf("labels of 13800000002 are %v, want the 3 labels untouched", got)
+	}
+	// the scans by label see the purge too.
+	var sample struct {
+		Total   int      `json:"total"`
+		Mobiles []string `json:"mobiles"`
+	}
+	mustRequest(t, db, http.MethodGet, "/mobiles/vip/sample", "", &sample)
+	if sample.Total != 1 || sample.Mobiles[0] != "13800000002" {
+		t.Errorf("mobiles of vip %+v, want only 13800000002", sample)
+	}
+
+	var res struct {
+		Removed int `json:"removed"`
+	}
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000001", "", &res)
+	if res.Removed != 0 {
+		t.Errorf("purged %d labels of the purged mobile again, want 0", res.Removed)
+	}
+}
+
+func TestMobileParseError(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+
+	lines := strings.Split(strings.TrimSuffix(mobilesFile(13800000001, 200), "\n"), "\n")
+	lines[149] = "1380000x150"
+	content := strings.Join(lines, "\n") + "\n"
+	writeTestFile(t, "bad.txt", content)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	zw.Close()
+	writeTestFile(t, "bad.txt.gz", buf.String())
+
+	// the bad line is in a chunk other than the first one of the multiple workers.
+	for _, target := range []string{"/load/bad.txt/vip?workers=1", "/load/bad.txt/vip?workers=4", "/load/bad.txt.gz/vip"} {
+		code, errMsg := request(t, db, http.MethodPost, target, "", nil)
+		if code != http.StatusBadRequest || !strings.Contains(errMsg, `"1380000x150"`) || !strings.Contains(errMsg, "line 150") {
+			t.Errorf("%s: status %d, error %q, want 400 with the bad value and line 150", target, code, errMsg)
+		}
+	}
+
+	_, err := mobile2bytes("abc")
+	var pe *MobileParseError
+	if !errors.As(err, &pe) || pe.Value != "abc" || !strings.Contains(err.Error(), `bad mobile "abc"`) {
+		t.Errorf("parse error %v, want the MobileParseError of abc", err)
+	}
+}
+
+func TestPartitionPrefix(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &PartitionPrefix, 3)
+	db := openTestDB(t, 16)
+
+	partitionOf := func(m string) uint64 {
+		mobile, err := mobile2bytes(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db.Partition(mobile)
+	}
+	p := partitionOf("13800000000")
+	for _, m := range []string{"13812345678", "13899999999", "13800000001"} {
+		if got := partitionOf(m); got != p {
+			t.Errorf("partition of %s is %d, want %d of the prefix 138", m, got, p)
+		}
+	}
+
+	writeTestFile(t, "a.txt", "13800000001\n13912345678\n")
+	load(t, db, "a.txt", "vip", "")
+
+	// the prefix is persisted in the meta and adopted on reopen.
+	PartitionPrefix = -1
+	db = reopenTestDB(t, db)
+	if PartitionPrefix != 3 {
+		t.Errorf("partition prefix %d after reopen, want the persisted 3", PartitionPrefix)
+	}
+	for _, m := range []string{"13800000001", "13912345678"} {
+		if got := labelsOf(t, db, m); len(got) != 1 {
+			t.Errorf("labels of %s are %v after reopen, want [vip]", m, got)
+		}
+	}
+
+	// a different prefix is refused.
+	closeTestDB(t, db)
+	PartitionPrefix = 4
+	if err := (&pebbleDB{}).Open(db.path, 16); err == nil {
+		t.Error("open with a partition prefix different from the persisted one should fail")
+	}
+}
+
+func TestIteratorClosedOnPanic(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	load(t, db, "a.txt", "vip", "")
+
+	mobile, _ := mobile2bytes("13800000001")
+	// the panic in the iteration is recovered into 500 by the handler wrapper.
+	h := wrapHandler(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+		return db.iterateLabelsIn(db.Partition(mobile), mobile, func(label, value []byte) {
+			panic("injected")
+		})
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/labels/13800000001", nil), nil)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "injected") {
+		t.Errorf("status %d, body %s, want 500 of the panic", w.Code, w.Body)
+	}
+
+	// the server survives.
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 {
+		t.Errorf("labels %v after the panic, want [vip]", got)
+	}
+	// pebble fails the close with the leaked iterators.
+	closeTestDB(t, db)
+}
+
+func TestReadOnly(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	load(t, db, "a.txt", "vip", "")
+
+	setGlobal(t, &ReadOnly, true)
+	db = reopenTestDB(t, db)
+	if len(db.dbc) != 0 {
+		t.Errorf("%d op channels in read-only mode, want none", len(db.dbc))
+	}
+
+	if code, errMsg := request(t, db, http.MethodPost, "/load/a.txt/big", "", nil); code != http.StatusForbidden || !strings.Contains(errMsg, "read-only") {
+		t.Errorf("load status %d, error %q, want 403 of read-only", code, errMsg)
+	}
+	if code, _ := request(t, db, http.MethodPut, "/labels/13800000001/big", "", nil); code != http.StatusForbidden {
+		t.Errorf("put status %d, want 403 of read-only", code)
+	}
+	mobile, _ := mobile2bytes("13800000001")
+	if err := db.Append(mobile, []byte("big")); err != ErrReadOnly {
+		t.Errorf("append error %v, want %v", err, ErrReadOnly)
+	}
+
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 || got[0] != "vip" {
+		t.Errorf("labels %v in read-only mode, want [vip]", got)
+	}
+	var ready struct {
+		Ready    bool `json:"ready"`
+		ReadOnly bool `json:"readonly"`
+	}
+	mustRequest(t, db, http.MethodGet, "/readyz", "", &ready)
+	if !ready.Ready || !ready.ReadOnly {
+		t.Errorf("readyz %+v, want ready and readonly", ready)
+	}
+}
+
+func TestPrettyResponse(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "a.txt", "big", "")
+
+	get := func(target string) (raw string, body map[string]any) {
+		w := httptest.NewRecorder()
+		newRouter(db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid JSON %q: %v", target, w.Body, err)
+		}
+		// the cost differs between the responses.
+		delete(body["body"].(map[string]any), "cost")
+		return w.Body.String(), body
+	}
+
+	compact, want := get("/labels/13800000001")
+	if strings.Count(compact, "\n") != 1 {
+		t.Errorf("response %q, want compact in one line", compact)
+	}
+	for _, target := range []string{"/labels/13800000001?pretty=true", "/labels/13800000001?pretty=y"} {
+		pretty, body := get(target)
+		if !strings.Contains(pretty, "\n  \"body\": {\n    ") {
+			t.Errorf("%s: response %q, want indented", target, pretty)
+		}
+		if fmt.Sprint(body) != fmt.Sprint(want) {
+			t.Errorf("%s: response %v, want the same content %v", target, body, want)
+		}
+	}
+
+	setGlobal(t, &Pretty, true)
+	if pretty, _ := get("/labels/13800000001"); !strings.Contains(pretty, "\n  \"body\"") {
+		t.Errorf("response %q by -pretty, want indented", pretty)
+	}
+}
+
+// TestLabelScansFollowForwardData asserts the label oriented queries agree with the forward lookups,
+// because they scan the forward keys, there is no reverse index to drift.
+func TestLabelScansFollowForwardData(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 10))
+	load(t, db, "a.txt", "vip", "")
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000002/vip", "", nil)
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000003/vip", "", nil)
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000004", "", nil)
+
+	var sample struct {
+		Total   int      `json:"total"`
+		Mobiles []string `json:"mobiles"`
+	}
+	mustRequest(t, db, http.MethodGet, "/mobiles/vip/sample?n=100", "", &sample)
+	sampled := map[string]bool{}
+	for _, m := range sample.Mobiles {
+		sampled[m] = true
+	}
+	if sample.Total != 7 || len(sampled) != 7 {
+		t.Errorf("%d of %d mobiles sampled, want all the 7 with vip", len(sampled), sample.Total)
+	}
+	for i := 0; i < 10; i++ {
+		m := fmt.Sprint(13800000001 + i)
+		if forward := len(labelsOf(t, db, m)) > 0; forward != sampled[m] {
+			t.Errorf("mobile %s has vip %t by the forward lookup, but %t by the label scan", m, forward, sampled[m])
+		}
+	}
+}
+
+// copyDB copies the files of the db as they are on disk, like after a crash of the process.
+func copyDB(t *testing.T, path, to string) {
+	t.Helper()
+	if err := filepath.WalkDir(filepath.Dir(path), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasPrefix(p, path) {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		dst := to + strings.TrimPrefix(p, path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0o644)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurableLoad(t *testing.T) {
+	dir := inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 20))
+
+	tables := func() (n int64) {
+		for _, d := range db.dbs {
+			for _, l := range d.Metrics().Levels {
+				n += l.NumFiles
+			}
+		}
+		return n
+	}
+	load(t, db, "a.txt", "vip", "")
+	if n := tables(); n != 0 {
+		t.Fatalf("%d tables after the load, want the keys in the memtables only", n)
+	}
+
+	// the durable load returns after the touched partitions are flushed and the WAL is synced.
+	var res loadResult
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/big?durable=y", "", &res)
+	if n := tables(); n == 0 {
+		t.Error("no tables after the durable load, want the memtables flushed")
+	}
+
+	// the copy without closing the db is what a crash leaves on disk.
+	copied := filepath.Join(dir, "copied")
+	copyDB(t, db.path, copied)
+	db2 := openTestDBAt(t, copied, 4)
+	if n := countLabeled(t, db2, 13800000001, 20, "big"); n != 20 {
+		t.Errorf("%d mobiles labeled big after reopen, want all the 20", n)
+	}
+}
+
+func TestScanFileLongLine(t *testing.T) {
+	inTempDir(t)
+	long := strings.Repeat("x", 1000)
+	for name, content := range map[string]string{
+		"middle.txt":  "a\n" + long + "\nb\n",
+		"first.txt":   long + "\na\n",
+		"last.txt":    "a\n" + long,
+		"only.txt":    long,
+		"spaces.txt":  "a\n\n\n" + long + "\n\n\nb",
+		"crossed.txt": strings.Repeat("c", 30) + "\n" + long + "\n" + strings.Repeat("d", 30) + "\n",
+	} {
+		writeTestFile(t, name, content)
+		for _, workers := range []int{1, 7, 64, 500} {
+			counts := map[string]int{}
+			var mu sync.Mutex
+			if err := scanFile(name, workers, false, func(line string) error {
+				mu.Lock()
+				counts[line]++
+				mu.Unlock()
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]int{}
+			for _, l := range strings.Fields(content) {
+				want[l]++
+			}
+			if fmt.Sprint(counts) != fmt.Sprint(want) {
+				t.Errorf("%s by %d workers: lines %v, want each line exactly once", name, workers, lineLens(counts))
+			}
+		}
+	}
+}
+
+// lineLens describes the lines by their lengths, for the long lines.
+func lineLens(counts map[string]int) (lens []string) {
+	for l, n := range counts {
+		lens = append(lens, fmt.Sprintf("%d bytes x%d", len(l), n))
+	}
+	return lens
+}
+
+func TestLoadWorkersLimit(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &ScanWorkers, 2)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+
+	if code, errMsg := request(t, db, http.MethodPost, "/load/a.txt/vip?workers=9", "", nil); code != http.StatusBadRequest || !strings.Contains(errMsg, "limit 8") {
+		t.Errorf("status %d, error %q of the workers beyond the limit, want 400", code, errMsg)
+	}
+	if code, _ := request(t, db, http.MethodPost, "/load/a.txt/vip?workers=100000000", "", nil); code != http.StatusBadRequest {
+		t.Errorf("status %d of the huge workers, want 400", code)
+	}
+	if res := load(t, db, "a.txt", "vip", "workers=8"); res.Written != 1 {
+		t.Errorf("written %d by the workers of the limit, want 1", res.Written)
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	cases := []struct {
+		seed      uint64
+		mobile    string
+		hash      uint64
+		partition uint64
+	}{
+		{0, "13800138000", 7316668389530364558, 14},
+		{12345, "13800138000", 6748645128201163438, 14},
+		{0, "13912345678", 10093289986910542279, 7},
+		{12345, "13912345678", 3952338200483801669, 5},
+	}
+	setGlobal(t, &HashSeed, 0)
+	for _, c := range cases {
+		HashSeed = c.seed
+		mobile, _ := mobile2bytes(c.mobile)
+		if got := Hash(mobile); got != c.hash || got%16 != c.partition {
+			t.Errorf("hash of %s by seed %d is %d in partition %d of 16, want %d in %d", c.mobile, c.seed, got, got%16, c.hash, c.partition)
+		}
+		// the seed 0 is the unseeded xxhash.
+		if c.seed == 0 && c.hash != xxhash.Sum64(mobile) {
+			t.Errorf("hash of %s by seed 0 differs from the unseeded xxhash", c.mobile)
+		}
+	}
+}
+
+func TestHashSeedPersisted(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &HashSeed, 12345)
+	setGlobal(t, &hashSeedSet, true)
+	db := openTestDB(t, 16)
+	writeTestFile(t, "a.txt", "13912345678\n")
+	load(t, db, "a.txt", "vip", "")
+	mobile, _ := mobile2bytes("13912345678")
+	if labels, err := db.FindLabelsInPartition(5, mobile); err != nil || len(labels) != 1 {
+		t.Errorf("labels %v in partition 5 of the seed, err %v, want [vip]", labels, err)
+	}
+
+	// the seed is adopted from the meta on reopen without the flag.
+	HashSeed, hashSeedSet = 0, false
+	db = reopenTestDB(t, db)
+	if HashSeed != 12345 {
+		t.Errorf("hash seed %d after reopen, want the persisted 12345", HashSeed)
+	}
+	if got := labelsOf(t, db, "13912345678"); len(got) != 1 {
+		t.Errorf("labels %v after reopen, want [vip]", got)
+	}
+
+	// a different seed is refused.
+	closeTestDB(t, db)
+	HashSeed, hashSeedSet = 1, true
+	if err := (&pebbleDB{}).Open(db.path, 16); err == nil {
+		t.Error("open with a hash seed different from the persisted one should fail")
+	}
+}
+
+func TestLoadWithBatch(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n")
+	writeTestFile(t, "b.txt", "13800000002\n")
+
+	load(t, db, "a.txt", "vip", "batch_id=b1")
+	// the re-load of the label under a different batch keeps the latest.
+	load(t, db, "b.txt", "vip", "batch_id=b2")
+
+	for m, want := range map[string]string{"13800000001": "b1", "13800000002": "b2"} {
+		var res struct {
+			Labels []LabelEntry `json:"labels"`
+		}
+		mustRequest(t, db, http.MethodGet, "/labels/"+m+"?with_batch=y", "", &res)
+		if len(res.Labels) != 1 || res.Labels[0] != (LabelEntry{Label: "vip", Batch: want}) {
+			t.Errorf("labels of %s are %+v, want vip of batch %s", m, res.Labels, want)
+		}
+	}
+}
+
+// writerGoroutines counts the goroutines consuming the op channels.
+func writerGoroutines(t *testing.T) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	// the goroutines of the same stack are grouped, like "2 @ 0x..." followed by the frames.
+	n := 0
+	for _, group := range strings.Split(buf.String(), "\n\n") {
+		if strings.Contains(group, "(*pebbleDB).consume") {
+			var count int
+			fmt.Sscanf(group, "%d @", &count)
+			n += count
+		}
+	}
+	return n
+}
+
+func TestManyPartitionsShareWriters(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &MaxWriters, 8)
+
+	before := writerGoroutines(t)
+	db := openTestDB(t, 200)
+	// the barrier runs all the writers, the goroutines not started yet are without the frames in the profile.
+	db.Barrier()
+	if n := len(db.dbc); n != 8 {
+		t.Errorf("%d op channels, want the 8 shared ones", n)
+	}
+	if n := writerGoroutines(t) - before; n != 8 {
+		t.Errorf("%d writer goroutines for 200 partitions, want the 8 shared ones", n)
+	}
+
+	// the partitions sharing the writers are all writable.
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 1000))
+	if res := load(t, db, "a.txt", "vip", ""); res.Written != 1000 {
+		t.Errorf("written %d, want 1000", res.Written)
+	}
+	if n := countLabeled(t, db, 13800000000, 1000, "vip"); n != 1000 {
+		t.Errorf("%d mobiles labeled, want 1000", n)
+	}
+
+	setGlobal(t, &MaxPartitions, 100)
+	if err := (&pebbleDB{}).Open(filepath.Join(t.TempDir(), "db"), 200); err == nil {
+		t.Error("open of the partitions beyond the cap should fail")
+	}
+}
+
+func TestPutLabelIfAbsent(t *testing.T) {
+	db := openTestDB(t, 4)
+
+	var res struct {
+		Written bool `json:"written"`
+	}
+	for i, want := range []bool{true, false} {
+		res.Written = !want
+		mustRequest(t, db, http.MethodPut, "/labels/13800000001/vip?ifabsent=true", "", &res)
+		if res.Written != want {
+			t.Errorf("conditional write %d: written %t, want %t", i, res.Written, want)
+		}
+	}
+
+	// the concurrent conditional writes of the same key are serialized, only one is written.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	written := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mobile, _ := mobile2bytes("13800000002")
+			ok, err := db.SetIfAbsent(mobile, []byte("vip"), nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				written++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if written != 1 {
+		t.Errorf("%d of the concurrent conditional writes are written, want 1", written)
+	}
+
+	// the unconditional write always writes.
+	mustRequest(t, db, http.MethodPut, "/labels/13800000001/vip", "", &res)
+	if !res.Written {
+		t.Error("the unconditional write should be written")
+	}
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 || got[0] != "vip" {
+		t.Errorf("labels %v, want [vip]", got)
+	}
+}
+
+func TestLoadGoroutineCap(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 50))
+
+	var res struct {
+		Lines uint64 `json:"lines"`
+		Sync  bool   `json:"sync"`
+	}
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/x?workers=4", "", &res)
+	if res.Sync {
+		t.Error("load without the cap scanned sequentially, want the workers")
+	}
+
+	// the test process always runs more than 1 goroutine.
+	setGlobal(t, &MaxGoroutines, 1)
+	res.Sync = false
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/y?workers=4", "", &res)
+	db.Barrier()
+	if !res.Sync || res.Lines != 50 {
+		t.Errorf("load over the cap: sync %t, %d lines, want the 50 lines scanned sequentially", res.Sync, res.Lines)
+	}
+	if n := countLabeled(t, db, 13800000001, 50, "y"); n != 50 {
+		t.Errorf("%d mobiles labeled y, want all the 50", n)
+	}
+
+	var stats struct {
+		Goroutines int `json:"goroutines"`
+	}
+	mustRequest(t, db, http.MethodGet, "/stats", "", &stats)
+	if stats.Goroutines <= 1 {
+		t.Errorf("stats of %d goroutines, want the goroutines of the process", stats.Goroutines)
+	}
+}
+
+func TestLoadSkipsComments(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "# header\n\n13800000001\n  \n#13800000002\n13800000003\n\n")
+
+	res := load(t, db, "a.txt", "vip", "comment=%23&sync=y")
+	if res.Skipped != 2 || res.Rejected != 0 {
+		t.Errorf("skipped %d, rejected %d, want the 2 comments skipped and none rejected", res.Skipped, res.Rejected)
+	}
+	for mobile, want := range map[string]int{"13800000001": 1, "13800000002": 0, "13800000003": 1} {
+		if got := labelsOf(t, db, mobile); len(got) != want {
+			t.Errorf("labels of %s are %v, want %d", mobile, got, want)
+		}
+	}
+
+	// the lines of another prefix are not comments, and fail the parse.
+	if code, _ := request(t, db, http.MethodPost, "/load/a.txt/x?comment=%2F%2F&sync=y", "", nil); code != http.StatusBadRequest {
+		t.Errorf("load with the comment prefix //: status %d, want the # lines failing it by 400", code)
+	}
+
+	// the default prefix applies without the comment in the query.
+	setGlobal(t, &CommentPrefix, "#")
+	writeTestFile(t, "b.txt", "#x\n13800000004\n\n")
+	if res := load(t, db, "b.txt", "vip", ""); res.Skipped != 1 {
+		t.Errorf("skipped %d by the default prefix, want 1", res.Skipped)
+	}
+}
+
+func TestLoadWarnings(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"vip": {MaxKeys: 10}})
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 9)+"#end\n")
+	writeTestFile(t, "b.ndjson", `{"mobile":"13900000001"}`+"\n{bad\n")
+
+	// the quota near the limit and the skipped comment warn, without failing the load.
+	res := load(t, db, "a.txt", "vip", "comment=%23")
+	want := []string{"label vip used 9 of the 10 keys quota", "1 empty or comment lines are skipped"}
+	if strings.Join(res.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings %q, want %q", res.Warnings, want)
+	}
+	if n := countLabeled(t, db, 13800000001, 9, "vip"); n != 9 {
+		t.Errorf("%d mobiles labeled with the warnings, want all the 9", n)
+	}
+
+	res = load(t, db, "b.ndjson", "big", "format=ndjson")
+	if len(res.Warnings) != 1 || !strings.HasPrefix(res.Warnings[0], "1 malformed records are rejected, the first: ") {
+		t.Errorf("warnings %q, want the rejected record", res.Warnings)
+	}
+
+	// the clean load has no warnings.
+	writeTestFile(t, "c.txt", mobilesFile(13700000001, 3))
+	if res := load(t, db, "c.txt", "big", ""); res.Warnings != nil {
+		t.Errorf("warnings %q of the clean load, want none", res.Warnings)
+	}
+}
+
+func TestLoadCounters(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"vip": {MaxKeys: 3}})
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "#head\n"+mobilesFile(13800000001, 5))
+	writeTestFile(t, "b.ndjson", `{"mobile":"13900000001"}`+"\n{bad\n#c\n"+`{"mobile":"x"}`+"\n")
+	writeTestFile(t, "c.txt", "13700000001\nx\n")
+
+	for _, c := range []struct {
+		file, label, query                 string
+		parsed, written, skipped, rejected uint64
+	}{
+		{"a.txt", "big", "comment=%23", 6, 5, 1, 0},
+		{"a.txt", "vip", "comment=%23", 6, 3, 3, 0}, // the 2 over the quota are skipped.
+		{"a.txt", "big", "comment=%23&noop=y", 6, 0, 6, 0},
+		{"b.ndjson", "big", "comment=%23&format=ndjson", 4, 1, 1, 2},
+	} {
+		res := load(t, db, c.file, c.label, c.query)
+		if res.Parsed != c.parsed || res.Written != c.written || res.Skipped != c.skipped || res.Rejected != c.rejected {
+			t.Errorf("load %s/%s?%s: %+v, want parsed %d, written %d, skipped %d, rejected %d",
+				c.file, c.label, c.query, res, c.parsed, c.written, c.skipped, c.rejected)
+		}
+		if res.Parsed != res.Written+res.Skipped+res.Rejected || res.Lines != res.Parsed {
+			t.Errorf("load %s/%s?%s: %+v, want parsed = written + skipped + rejected", c.file, c.label, c.query, res)
+		}
+	}
+
+	// the bad mobile of the line format fails the load, instead of being rejected.
+	if code, errMsg := request(t, db, http.MethodPost, "/load/c.txt/big?sync=y", "", nil); code != http.StatusBadRequest || !strings.Contains(errMsg, "line 2") {
+		t.Errorf("load of the bad mobile: status %d, error %s, want 400 of line 2", code, errMsg)
+	}
+}
+
+// writeTarFile writes the files into the tar archive of the name, gzipped unless named .tar,
+// with a dir and a symlink entry which are skipped by the loads.
+func writeTarFile(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []*tar.Header{
+		{Name: "south/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "link.txt", Typeflag: tar.TypeSymlink, Linkname: "south/gd.txt"},
+	}
+	for _, h := range entries {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !strings.HasSuffix(name, ".tar") {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+		data = zbuf.Bytes()
+	}
+	writeTestFile(t, name, string(data))
+}
+
+func TestLoadTar(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	files := map[string]string{
+		"south/gd.txt":  "13800000001\n13800000002\n",
+		"north/bj.list": "13800000003\n",
+	}
+	writeTarFile(t, "a.tar", files)
+	writeTarFile(t, "a.tgz", files)
+
+	if res := load(t, db, "a.tar", "vip", ""); res.Written != 3 {
+		t.Errorf("written %d of the tar, want the 3 lines of the regular files", res.Written)
+	}
+	if n := countLabeled(t, db, 13800000001, 3, "vip"); n != 3 {
+		t.Errorf("%d mobiles labeled vip, want 3", n)
+	}
+
+	// the labels are derived from the entry names.
+	if res := load(t, db, "a.tgz", "x", "entry_label=y"); res.Written != 3 {
+		t.Errorf("written %d of the tgz, want 3", res.Written)
+	}
+	for mobile, want := range map[string]string{"13800000001": "gd,vip", "13800000002": "gd,vip", "13800000003": "bj,vip"} {
+		if got := strings.Join(labelsOf(t, db, mobile), ","); got != want {
+			t.Errorf("labels of %s are %s, want %s", mobile, got, want)
+		}
+	}
+
+	if code, _ := request(t, db, http.MethodPost, "/load/a.tar/vip?follow=y", "", nil); code != http.StatusBadRequest {
+		t.Errorf("follow of the tar: status %d, want 400", code)
+	}
+}
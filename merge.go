@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// MergeStats is the stats of merging databases.
+type MergeStats struct {
+	Keys      uint64 // total keys read from the sources
+	Merged    uint64 // keys newly written to the target
+	Dups      uint64 // keys already in the target with the same value
+	Conflicts uint64 // keys already in the target with a different value (regardless of the write seq), the first one is kept
+}
+
+// findPartitionDirs finds the partition dirs of the db path, like path.0, path.1, ... in the flat layout,
+// or path/0000/db, path/0001/db, ... in the nested layout.
+func findPartitionDirs(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, m := range matches {
+		if _, err := strconv.ParseUint(strings.TrimPrefix(m, path+"."), 10, 64); err == nil {
+			dirs = append(dirs, m)
+		}
+	}
+	if len(dirs) == 0 {
+		if matches, err = filepath.Glob(filepath.Join(path, "*", "db")); err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if _, err := strconv.ParseUint(filepath.Base(filepath.Dir(m)), 10, 64); err == nil {
+				dirs = append(dirs, m)
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no partitions found for %s", path)
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// Merge merges the source databases offline into s, re-partitioning the keys by the partitions of s.
+// The labels of the same mobile are deduplicated naturally because they are the same keys.
+// The keys of a source without datasets are merged into the default dataset of s with datasets.
+func (s *pebbleDB) Merge(sources []string) (stats MergeStats, err error) {
+	for _, source := range sources {
+		dirs, err := findPartitionDirs(source)
+		if err != nil {
+			return stats, err
+		}
+		// the keys of the source are laid out by its own datasets mode, persisted in its meta.
+		srcMeta := metaStore{readonly: true}
+		if err := srcMeta.open(source); err != nil {
+			return stats, err
+		}
+		srcDatasets := srcMeta.Datasets != nil && *srcMeta.Datasets
+		if srcDatasets && !Datasets {
+			return stats, fmt.Errorf("source %s has datasets, which the target has not", source)
+		}
+
+		before := stats
+		for _, dir := range dirs {
+			if err := s.mergePartition(dir, srcDatasets, &stats); err != nil {
+				return stats, fmt.Errorf("merge %s: %w", dir, err)
+			}
+		}
+		logAt(LevelInfo, "merged %s with %d partitions, keys: %d, merged: %d, dups: %d, conflicts: %d", source, len(dirs),
+			stats.Keys-before.Keys, stats.Merged-before.Merged, stats.Dups-before.Dups, stats.Conflicts-before.Conflicts)
+	}
+
+	return stats, nil
+}
+
+// sameLabelValue tells whether the values of the same label are the same, regardless of the write seq,
+// which differs by the dbs written.
+func sameLabelValue(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	va, vb := DecodeLabelValue(a), DecodeLabelValue(b)
+	va.Seq, vb.Seq = 0, 0
+	return va == vb
+}
+
+func (s *pebbleDB) mergePartition(dir string, srcDatasets bool, stats *MergeStats) error {
+	src, err := pebble.Open(dir, &pebble.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return scanBatches(src, nil, func(batch []KV) error {
+		for _, kv := range batch {
+			key, value := kv.Key, kv.Value
+			n := mobileKeyLenOf(key, srcDatasets)
+			if n < 0 {
+				continue
+			}
+			if Datasets && !srcDatasets {
+				// into the default dataset, prefixed by the zero length of its empty name.
+				key = append([]byte{0}, key...)
+				n++
+			}
+
+			stats.Keys++
+			dst := s.dbs[s.Partition(key[:n])]
+			old, closer, err := dst.Get(key)
+			if err == nil {
+				if sameLabelValue(old, value) {
+					stats.Dups++
+				} else {
+					stats.Conflicts++
+				}
+				closer.Close()
+				continue
+			} else if err != pebble.ErrNotFound {
+				return err
+			}
+
+			if err := dst.Set(key, value, pebble.NoSync); err != nil {
+				return err
+			}
+			s.hll.Add(key[:n])
+			stats.Merged++
+		}
+		return nil
+	})
+}
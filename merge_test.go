@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n")
+	writeTestFile(t, "b.txt", "13800000002\n13800000003\n")
+	// the same labels are stamped with the different seqs by the sources.
+	setGlobal(t, &WriteSeq, true)
+
+	// the sources are partitioned differently from each other and the target.
+	a := openTestDB(t, 2)
+	load(t, a, "a.txt", "vip", "")
+	closeTestDB(t, a)
+
+	b := openTestDB(t, 3)
+	load(t, b, "b.txt", "big", "")
+	load(t, b, "a.txt", "vip", "")
+	closeTestDB(t, b)
+
+	db := openTestDB(t, 4)
+	stats, err := db.Merge([]string{a.path, b.path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the vip labels of 13800000001 and 13800000002 are in both sources, the same regardless of the seqs.
+	if stats.Keys != 6 || stats.Merged != 4 || stats.Dups != 2 || stats.Conflicts != 0 {
+		t.Errorf("merge stats %+v, want 6 keys, 4 merged and 2 dups", stats)
+	}
+
+	for m, want := range map[string]string{"13800000001": "vip", "13800000002": "big,vip", "13800000003": "big"} {
+		got := labelsOf(t, db, m)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("labels of %s are %v, want the union %s", m, got, want)
+		}
+	}
+}
+
+func TestMergeIntoDatasets(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "a.txt", "13800000001\n")
+
+	a := openTestDB(t, 2)
+	load(t, a, "a.txt", "vip", "")
+	closeTestDB(t, a)
+
+	// the keys of the source without datasets are laid out by its meta, not the datasets of the target.
+	setGlobal(t, &Datasets, true)
+	db := openTestDB(t, 4)
+	stats, err := db.Merge([]string{a.path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 1 || stats.Merged != 1 {
+		t.Errorf("merge stats %+v, want 1 key merged", stats)
+	}
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 || got[0] != "vip" {
+		t.Errorf("labels of 13800000001 in the default dataset are %v, want [vip]", got)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Meta is the metadata of the db, persisted in the json file {path}.meta.json.
+type Meta struct {
+	// Loads are the loads in progress, a load left here after restart was interrupted.
+	Loads map[string]*LoadProgress `json:"loads,omitempty"`
+	// Usage is the storage usage by label, only the labels with quotas are tracked.
+	Usage map[string]LabelUsage `json:"usage,omitempty"`
+	// PartitionPrefix is the PartitionPrefix the db is created with.
+	PartitionPrefix *int `json:"partitionPrefix,omitempty"`
+	// HashSeed is the HashSeed the db is created with, the dbs created before it was introduced are with seed 0.
+	HashSeed *uint64 `json:"hashSeed,omitempty"`
+	// Layout is the PartitionLayout the db is created with, the dbs created before it was introduced are flat.
+	Layout string `json:"layout,omitempty"`
+	// Datasets is the Datasets the db is created with, the dbs created before it was introduced are without datasets.
+	Datasets *bool `json:"datasets,omitempty"`
+	// HLL is the registers of the HyperLogLog of the distinct mobiles.
+	HLL []byte `json:"hll,omitempty"`
+}
+
+// LoadProgress is the progress of a load.
+type LoadProgress struct {
+	File  string `json:"file"`
+	Label string `json:"label"`
+	// Size is the size of the file when the load began.
+	Size int64 `json:"size"`
+	// Committed is the bytes of the lines whose writes were applied, recorded every LoadProgressInterval
+	// and when the load fails. The chunks of the file are scanned concurrently, so it tells how much of the file
+	// was loaded, not an offset to resume from.
+	Committed uint64    `json:"committed"`
+	Started   time.Time `json:"started"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// LoadProgressInterval is the interval to record the committed bytes of the loads in the meta.
+var LoadProgressInterval = 10 * time.Second
+
+type metaStore struct {
+	sync.Mutex
+	path     string
+	readonly bool // the meta is not saved for the readonly db
+	Meta
+	// incomplete are the loads which were interrupted or failed, the db is not ready until they are cleared.
+	incomplete map[string]*LoadProgress
+}
+
+func (m *metaStore) open(path string) error {
+	m.path = path + ".meta.json"
+	m.incomplete = map[string]*LoadProgress{}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.Meta); err != nil {
+		return fmt.Errorf("parse meta %s: %w", m.path, err)
+	}
+
+	for k, v := range m.Loads {
+		m.incomplete[k] = v
+	}
+	return nil
+}
+
+// save saves the meta, the caller should hold the lock.
+func (m *metaStore) save() error {
+	if m.readonly {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.Meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the dir of the db path may not exist yet for a new db in the nested layout or spread across the base dirs.
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// beginLoad records the load in the meta before any data is written, returns the key of the load.
+func (m *metaStore) beginLoad(file, label string) (string, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+
+	key := file + "/" + label
+	m.Lock()
+	defer m.Unlock()
+
+	if m.Loads == nil {
+		m.Loads = map[string]*LoadProgress{}
+	}
+	m.Loads[key] = &LoadProgress{File: file, Label: label, Size: stat.Size(), Started: time.Now()}
+	return key, m.save()
+}
+
+// setCommitted records the committed bytes of the load.
+func (m *metaStore) setCommitted(key string, committed uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	p := m.Loads[key]
+	if p == nil {
+		return nil
+	}
+	p.Committed = committed
+	return m.save()
+}
+
+// trackLoadProgress records the committed bytes of the load every LoadProgressInterval until stopped.
+// committed returns the bytes of the lines handled, which are committed after the queued writes are applied.
+func (s *pebbleDB) trackLoadProgress(key string, committed func() uint64) (stop func()) {
+	ticker := time.NewTicker(LoadProgressInterval)
+	stopped, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				n := committed()
+				s.Barrier()
+				if err := s.meta.setCommitted(key, n); err != nil {
+					logAt(LevelError, "record the progress of load %s failed: %v", key, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stopped)
+		<-done
+	}
+}
+
+// endLoad removes the load from the meta when it is complete, or failed before any key was written,
+// or flags it as incomplete when it is failed after written some keys, because the data is partially written.
+// A complete load also clears the incomplete flag of the same file and label left by a previous load,
+// because the reload supersedes the partial data. committed is the bytes committed by the failed load.
+func (m *metaStore) endLoad(key string, loadErr error, written, committed uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if loadErr != nil && written > 0 {
+		if p := m.Loads[key]; p != nil {
+			p.Error = loadErr.Error()
+			p.Committed = committed
+			m.incomplete[key] = p
+		}
+		return m.save()
+	}
+
+	delete(m.Loads, key)
+	if loadErr == nil {
+		delete(m.incomplete, key)
+	}
+	return m.save()
+}
+
+// checkPartitioning checks the PartitionPrefix, HashSeed, PartitionLayout and Datasets are the same with the persisted ones,
+// because the mobiles are unreachable when partitioned differently, or persists them for a new db.
+func (m *metaStore) checkPartitioning() error {
+	m.Lock()
+	defer m.Unlock()
+
+	existing := m.PartitionPrefix != nil
+	changed := false
+	if m.Layout == "" && existing {
+		m.Layout = LayoutFlat
+		changed = true
+	}
+	if l := m.Layout; l != "" {
+		if PartitionLayout != "" && PartitionLayout != l {
+			return fmt.Errorf("partition layout %s differs from %s persisted in %s", PartitionLayout, l, m.path)
+		}
+		PartitionLayout = l
+	} else {
+		if PartitionLayout == "" {
+			PartitionLayout = LayoutFlat
+		}
+		m.Layout = PartitionLayout
+		changed = true
+	}
+
+	if p := m.PartitionPrefix; p != nil {
+		if PartitionPrefix >= 0 && PartitionPrefix != *p {
+			return fmt.Errorf("partition prefix %d differs from %d persisted in %s", PartitionPrefix, *p, m.path)
+		}
+		PartitionPrefix = *p
+	} else {
+		if PartitionPrefix < 0 {
+			PartitionPrefix = 0
+		}
+		p := PartitionPrefix
+		m.PartitionPrefix = &p
+		changed = true
+	}
+
+	if m.Datasets == nil && existing {
+		no := false
+		m.Datasets = &no
+		changed = true
+	}
+	if d := m.Datasets; d != nil {
+		if Datasets && !*d {
+			return fmt.Errorf("datasets are not enabled when the db is created, as persisted in %s", m.path)
+		}
+		Datasets = *d
+	} else {
+		d := Datasets
+		m.Datasets = &d
+		changed = true
+	}
+
+	if seed := m.HashSeed; seed != nil {
+		if hashSeedSet && HashSeed != *seed {
+			return fmt.Errorf("hash seed %d differs from %d persisted in %s", HashSeed, *seed, m.path)
+		}
+		HashSeed = *seed
+	} else {
+		seed := HashSeed
+		m.HashSeed = &seed
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return m.save()
+}
+
+func (m *metaStore) saveHLL(h *HyperLogLog) error {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.HLL = data
+	return m.save()
+}
+
+func (m *metaStore) labelUsage(label string) LabelUsage {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Usage[label]
+}
+
+func (m *metaStore) setLabelUsage(label string, usage LabelUsage) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.Usage == nil {
+		m.Usage = map[string]LabelUsage{}
+	}
+	m.Usage[label] = usage
+	return m.save()
+}
+
+func (m *metaStore) incompleteLoads() (loads []*LoadProgress) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, v := range m.incomplete {
+		loads = append(loads, v)
+	}
+	sort.Slice(loads, func(i, j int) bool { return loads[i].Started.Before(loads[j].Started) })
+	return loads
+}
+
+// clearIncomplete clears the incomplete loads after the operator checked them.
+func (m *metaStore) clearIncomplete() (loads []*LoadProgress, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for k, v := range m.incomplete {
+		loads = append(loads, v)
+		delete(m.Loads, k)
+	}
+	m.incomplete = map[string]*LoadProgress{}
+	return loads, m.save()
+}
+
+// Readyz responds 503 when there are incomplete loads, which means the db may hold partially loaded data.
+func (s *pebbleDB) Readyz(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+	if loads := s.meta.incompleteLoads(); len(loads) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return jsonResponse(w, H{"ready": false, "readonly": s.readonly, "incompleteLoads": loads})
+	}
+
+	return jsonResponse(w, H{"ready": true, "readonly": s.readonly})
+}
+
+// ClearIncompleteLoads clears the incomplete loads flags, so that /readyz becomes ready.
+func (s *pebbleDB) ClearIncompleteLoads(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+	loads, err := s.meta.clearIncomplete()
+	if err != nil {
+		return err
+	}
+
+	return jsonResponse(w, H{"cleared": loads})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type readyzResult struct {
+	Ready           bool            `json:"ready"`
+	IncompleteLoads []*LoadProgress `json:"incompleteLoads"`
+}
+
+func readyz(t *testing.T, db *pebbleDB) (int, readyzResult) {
+	t.Helper()
+	var res readyzResult
+	code, _ := request(t, db, http.MethodGet, "/readyz", "", &res)
+	return code, res
+}
+
+func TestReadyzInterruptedLoad(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n13800000002\n")
+
+	// a crash mid-load leaves the load begun but not ended in the meta.
+	if _, err := db.meta.beginLoad("a.txt", "vip"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := readyz(t, db); code != http.StatusOK {
+		t.Errorf("readyz %d of the load in progress, want 200", code)
+	}
+
+	db = reopenTestDB(t, db)
+	code, res := readyz(t, db)
+	if code != http.StatusServiceUnavailable || res.Ready || len(res.IncompleteLoads) != 1 {
+		t.Fatalf("readyz %d %+v after reopen, want 503 of the interrupted load", code, res)
+	}
+	if p := res.IncompleteLoads[0]; p.File != "a.txt" || p.Label != "vip" || p.Size != 24 || p.Committed != 0 {
+		t.Errorf("incomplete load %+v, want a.txt/vip of size 24 and nothing committed", p)
+	}
+
+	// a complete reload of the same file and label clears the flag.
+	load(t, db, "a.txt", "vip", "")
+	if code, res := readyz(t, db); code != http.StatusOK || !res.Ready {
+		t.Errorf("readyz %d %+v after the reload, want 200", code, res)
+	}
+}
+
+func TestReadyzFailedLoad(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	setGlobal(t, &AdminToken, "secret")
+	writeTestFile(t, "bad.txt", "abc\n")
+	writeTestFile(t, "partial.txt", "13800000001\n13800000002\nabc\n")
+
+	// the load failed before any key was written is not flagged.
+	if code, _ := request(t, db, http.MethodPost, "/load/bad.txt/vip", "", nil); code == http.StatusOK {
+		t.Fatal("the load of the bad mobile should fail")
+	}
+	if code, _ := readyz(t, db); code != http.StatusOK {
+		t.Errorf("readyz %d of the load failed without writes, want 200", code)
+	}
+
+	// a single worker writes the second line before it fails at the third, the first line is joined after all the chunks.
+	if code, _ := request(t, db, http.MethodPost, "/load/partial.txt/vip?workers=1", "", nil); code == http.StatusOK {
+		t.Fatal("the load of the bad mobile should fail")
+	}
+	code, res := readyz(t, db)
+	if code != http.StatusServiceUnavailable || len(res.IncompleteLoads) != 1 {
+		t.Fatalf("readyz %d %+v of the load failed after writes, want 503", code, res)
+	}
+	// only the second line is committed.
+	if p := res.IncompleteLoads[0]; p.Size != 28 || p.Committed != 12 {
+		t.Errorf("incomplete load %+v, want 12 of the 28 bytes committed", p)
+	}
+
+	// the operator clears the flag.
+	if code, _ := adminRequest(t, db, http.MethodDelete, "/admin/loads/incomplete", "", "secret", nil); code != http.StatusOK {
+		t.Fatalf("clear incomplete loads status %d", code)
+	}
+	if code, _ := readyz(t, db); code != http.StatusOK {
+		t.Errorf("readyz %d after cleared, want 200", code)
+	}
+}
+
+func TestLoadProgress(t *testing.T) {
+	setGlobal(t, &LoadProgressInterval, time.Millisecond)
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+	key, err := db.meta.beginLoad("a.txt", "vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var committed atomic.Uint64
+	committed.Store(100)
+	stop := db.trackLoadProgress(key, committed.Load)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	// the progress is persisted, and survives a crash.
+	db = reopenTestDB(t, db)
+	if _, res := readyz(t, db); len(res.IncompleteLoads) != 1 || res.IncompleteLoads[0].Committed != 100 {
+		t.Errorf("incomplete loads %+v after reopen, want the 100 bytes committed", res.IncompleteLoads)
+	}
+}
+
+func TestReadOnlyMetaNotSaved(t *testing.T) {
+	db := openTestDB(t, 4)
+	closeTestDB(t, db)
+	// the meta of a db created before the partitioning was persisted.
+	if err := os.Remove(db.meta.path); err != nil {
+		t.Fatal(err)
+	}
+
+	setGlobal(t, &ReadOnly, true)
+	openTestDBAt(t, db.path, 4)
+	if _, err := os.Stat(db.meta.path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("meta of the readonly db is saved: %v", err)
+	}
+}
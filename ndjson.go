@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// NDJSONFields is the field names to extract the mobile and label from the newline delimited JSON records.
+type NDJSONFields struct {
+	Mobile string
+	Label  string
+}
+
+// parse parses a JSON record line, the label falls back to defaultLabel when the record has no label field.
+func (f NDJSONFields) parse(line, defaultLabel string) (mobile, label string, err error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(line)))
+	dec.UseNumber()
+	var rec map[string]interface{}
+	if err := dec.Decode(&rec); err != nil {
+		return "", "", fmt.Errorf("bad record %q: %w", line, err)
+	}
+
+	switch v := rec[f.Mobile].(type) {
+	case json.Number:
+		mobile = v.String()
+	case string:
+		mobile = v
+	default:
+		return "", "", fmt.Errorf("bad record %q: missing string or number field %q", line, f.Mobile)
+	}
+
+	label = defaultLabel
+	if v, ok := rec[f.Label]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return "", "", fmt.Errorf("bad record %q: field %q is not a string", line, f.Label)
+		}
+		if s != "" {
+			label = NormalizeLabel(s)
+		}
+	}
+	return mobile, label, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNDJSONParse(t *testing.T) {
+	f := NDJSONFields{Mobile: "phone", Label: "tag"}
+	cases := []struct {
+		line, mobile, label string
+		bad                 bool
+	}{
+		{`{"phone":"13800000001","tag":"vip"}`, "13800000001", "vip", false},
+		{`{"phone":13800000002,"tag":"big spender"}`, "13800000002", "big spender", false},
+		{`{"phone":"13800000003"}`, "13800000003", "default", false},
+		{`{"phone":"13800000004","tag":""}`, "13800000004", "default", false},
+		{`{"phone":"13800000005",`, "", "", true},
+		{`{"mobile":"13800000006"}`, "", "", true},
+		{`{"phone":"13800000007","tag":1}`, "", "", true},
+	}
+	for _, c := range cases {
+		mobile, label, err := f.parse(c.line, "default")
+		if c.bad {
+			if err == nil {
+				t.Errorf("parse %s should fail", c.line)
+			}
+			continue
+		}
+		if err != nil || mobile != c.mobile || label != c.label {
+			t.Errorf("parse %s = %s, %s, %v, want %s, %s", c.line, mobile, label, err, c.mobile, c.label)
+		}
+	}
+}
+
+func TestLoadNDJSON(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.ndjson", strings.Join([]string{
+		`{"phone": "13800000001", "tag": "vip"}`,
+		`{"phone": 13800000002, "tag": "big spender", "mobile": "13900000000"}`,
+		`{"phone": "13800000003"}`,
+		``,
+		`{"phone": "13800000004", "tag": `,
+		`{"phone": "abc", "tag": "vip"}`,
+	}, "\n")+"\n")
+
+	// many workers, the records are read sequentially keeping the spaces in them.
+	res := load(t, db, "a.ndjson", "default", "format=ndjson&mobile_field=phone&label_field=tag&workers=4")
+	if res.Written != 3 || res.Rejected != 2 || res.Parsed != res.Written+res.Skipped+res.Rejected {
+		t.Errorf("load result %+v, want 3 written and 2 rejected", res)
+	}
+
+	for m, want := range map[string]string{"13800000001": "vip", "13800000002": "big spender", "13800000003": "default"} {
+		if got := labelsOf(t, db, m); len(got) != 1 || got[0] != want {
+			t.Errorf("labels of %s are %q, want [%s]", m, got, want)
+		}
+	}
+	for _, m := range []string{"13900000000", "13800000004"} {
+		if got := labelsOf(t, db, m); len(got) != 0 {
+			t.Errorf("labels of %s are %v, want none", m, got)
+		}
+	}
+
+	// the default fields are mobile and label.
+	writeTestFile(t, "b.ndjson", `{"mobile":"13800000009","label":"new"}`+"\n")
+	load(t, db, "b.ndjson", "default", "format=ndjson")
+	if got := labelsOf(t, db, "13800000009"); len(got) != 1 || got[0] != "new" {
+		t.Errorf("labels of 13800000009 are %v, want [new]", got)
+	}
+}
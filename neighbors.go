@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxNeighbors is the max k of the neighbors query.
+const MaxNeighbors = 1000
+
+// NeighborsTTL is the time to live of the cached neighbors results.
+var NeighborsTTL = time.Minute
+
+// MaxNeighborScans is the max number of the concurrent full scans of the neighbors queries,
+// the queries beyond it are rejected with 429 unless they hit the cache.
+var MaxNeighborScans = 2
+
+type neighborsResult struct {
+	below, above []Neighbor
+}
+
+// Neighbor is a mobile with its labels in the neighbors response.
+type Neighbor struct {
+	Mobile string   `json:"mobile"`
+	Labels []string `json:"labels"`
+
+	n uint64
+}
+
+// Neighbors lists the numerically nearest mobiles with labels, up to k on each side of the mobile.
+//
+// The keys are little-endian encoded and hash partitioned, so the numeric neighbors are neither adjacent in a partition
+// nor in the same partition, and it scans all the partitions concurrently, keeping the nearest k of each side.
+// The full scans are expensive, so the results are cached for NeighborsTTL, and the concurrent scans are limited by MaxNeighborScans.
+func (s *pebbleDB) Neighbors(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	mobile, err := mobile2bytes(p.ByName("mobile"))
+	if err != nil {
+		return err
+	}
+	k := 10
+	if v := r.URL.Query().Get("k"); v != "" {
+		if k, err = strconv.Atoi(v); err != nil {
+			return err
+		}
+	}
+	if k <= 0 || k > MaxNeighbors {
+		return &StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("k should be in [1, %d]", MaxNeighbors)}
+	}
+
+	key := fmt.Sprintf("%x/%d/%d", ds.prefix, bytes2uint64(mobile), k)
+	result, cached, err := s.neighborsCache.get(key, NeighborsTTL, func() (*neighborsResult, error) {
+		select {
+		case s.neighborScans <- struct{}{}:
+			defer func() { <-s.neighborScans }()
+		default:
+			return nil, &StatusError{Code: http.StatusTooManyRequests, Err: fmt.Errorf("too many neighbors queries in progress")}
+		}
+		below, above, err := s.neighbors(ds, bytes2uint64(mobile), k)
+		return &neighborsResult{below: below, above: above}, err
+	})
+	if err != nil {
+		return err
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "cached": cached, "mobile": bytes2mobile(mobile), "below": result.below, "above": result.above})
+}
+
+// neighbors returns the nearest k mobiles below the target in the descending order, and above it in the ascending order.
+func (s *pebbleDB) neighbors(ds dataset, target uint64, k int) (below, above []Neighbor, err error) {
+	belows := make([][]Neighbor, len(s.dbs))
+	aboves := make([][]Neighbor, len(s.dbs))
+	errs := make([]error, len(s.dbs))
+
+	var wg sync.WaitGroup
+	for i := range s.dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			errs[i] = s.iterateMobiles(i, ds, func(mobile []byte, labels [][]byte) {
+				n := bytes2uint64(mobile)
+				switch {
+				case n < target:
+					belows[i] = nearest(belows[i], mobile, labels, k, func(a, b uint64) bool { return a > b })
+				case n > target:
+					aboves[i] = nearest(aboves[i], mobile, labels, k, func(a, b uint64) bool { return a < b })
+				}
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range s.dbs {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+	}
+	return mergeNearest(belows, k, func(a, b uint64) bool { return a > b }),
+		mergeNearest(aboves, k, func(a, b uint64) bool { return a < b }), nil
+}
+
+// nearest inserts the mobile into the sorted neighbors, if it is one of the nearest k by the closer order.
+func nearest(neighbors []Neighbor, mobile []byte, labels [][]byte, k int, closer func(a, b uint64) bool) []Neighbor {
+	n := bytes2uint64(mobile)
+	if len(neighbors) == k && !closer(n, neighbors[k-1].n) {
+		return neighbors
+	}
+
+	nb := Neighbor{Mobile: bytes2mobile(mobile), n: n}
+	for _, l := range labels {
+		nb.Labels = append(nb.Labels, string(l))
+	}
+	i := sort.Search(len(neighbors), func(i int) bool { return closer(n, neighbors[i].n) })
+	if len(neighbors) < k {
+		neighbors = append(neighbors, Neighbor{})
+	}
+	copy(neighbors[i+1:], neighbors[i:])
+	neighbors[i] = nb
+	return neighbors
+}
+
+// mergeNearest merges the nearest neighbors of the partitions to the nearest k.
+func mergeNearest(partitions [][]Neighbor, k int, closer func(a, b uint64) bool) []Neighbor {
+	merged := []Neighbor{}
+	for _, neighbors := range partitions {
+		merged = append(merged, neighbors...)
+	}
+	sort.Slice(merged, func(i, j int) bool { return closer(merged[i].n, merged[j].n) })
+	if len(merged) > k {
+		merged = merged[:k]
+	}
+	return merged
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// neighborsResponse is the response body of the neighbors query.
+type neighborsResponse struct {
+	Cached bool       `json:"cached"`
+	Below  []Neighbor `json:"below"`
+	Above  []Neighbor `json:"above"`
+}
+
+// neighborMobiles returns the mobiles of the neighbors joined by commas.
+func neighborMobiles(neighbors []Neighbor) string {
+	var mobiles []string
+	for _, n := range neighbors {
+		mobiles = append(mobiles, n.Mobile)
+	}
+	return strings.Join(mobiles, ",")
+}
+
+func TestNeighbors(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	// the mobiles spread across the partitions, with gaps around the target 13800000010.
+	writeTestFile(t, "a.txt", "13800000001\n13800000005\n13800000007\n13800000010\n13800000012\n13800000020\n")
+	writeTestFile(t, "b.txt", "13800000007\n13800000030\n")
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "b.txt", "big", "")
+
+	var res neighborsResponse
+	mustRequest(t, db, http.MethodGet, "/labels/13800000010/neighbors?k=2", "", &res)
+	if got := neighborMobiles(res.Below); got != "13800000007,13800000005" {
+		t.Errorf("below %s, want the nearest 13800000007,13800000005", got)
+	}
+	if got := neighborMobiles(res.Above); got != "13800000012,13800000020" {
+		t.Errorf("above %s, want the nearest 13800000012,13800000020", got)
+	}
+	if len(res.Below) > 0 && strings.Join(res.Below[0].Labels, ",") != "big,vip" {
+		t.Errorf("labels %v of 13800000007, want big,vip", res.Below[0].Labels)
+	}
+	if res.Cached {
+		t.Error("the first query should not be cached")
+	}
+
+	// fewer than k on a side, and the target without labels.
+	mustRequest(t, db, http.MethodGet, "/labels/13800000004/neighbors?k=3", "", &res)
+	if got := neighborMobiles(res.Below); got != "13800000001" {
+		t.Errorf("below %s, want only 13800000001", got)
+	}
+	if got := neighborMobiles(res.Above); got != "13800000005,13800000007,13800000010" {
+		t.Errorf("above %s, want 13800000005,13800000007,13800000010", got)
+	}
+
+	for _, k := range []string{"0", "1001", "x"} {
+		if code, _ := request(t, db, http.MethodGet, "/labels/13800000010/neighbors?k="+k, "", nil); code != http.StatusBadRequest {
+			t.Errorf("k=%s: status %d, want 400", k, code)
+		}
+	}
+}
+
+func TestNeighborsCacheAndLimit(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 10))
+	load(t, db, "a.txt", "vip", "")
+
+	var res neighborsResponse
+	mustRequest(t, db, http.MethodGet, "/labels/13800000005/neighbors?k=2", "", &res)
+
+	// takes all the scan slots, the uncached queries are rejected, but the cached one is served.
+	for i := 0; i < cap(db.neighborScans); i++ {
+		db.neighborScans <- struct{}{}
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000005/neighbors?k=2", "", &res)
+	if !res.Cached || neighborMobiles(res.Above) != "13800000006,13800000007" {
+		t.Errorf("cached %t, above %s, want the cached 13800000006,13800000007", res.Cached, neighborMobiles(res.Above))
+	}
+	if code, _ := request(t, db, http.MethodGet, "/labels/13800000005/neighbors?k=3", "", nil); code != http.StatusTooManyRequests {
+		t.Errorf("uncached query with the scans busy: status %d, want 429", code)
+	}
+
+	for i := 0; i < cap(db.neighborScans); i++ {
+		<-db.neighborScans
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000005/neighbors?k=3", "", &res)
+	if res.Cached || len(res.Above) != 3 {
+		t.Errorf("cached %t, %d above, want the 3 scanned after the scans are free", res.Cached, len(res.Above))
+	}
+}
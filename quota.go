@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LabelQuota is the storage quota of a label, 0 means unlimited.
+type LabelQuota struct {
+	MaxKeys  uint64 `json:"maxKeys,omitempty"`
+	MaxBytes uint64 `json:"maxBytes,omitempty"`
+}
+
+// LabelQuotas are the quotas by label, the quota of label * is the default for the other labels.
+var LabelQuotas = map[string]LabelQuota{}
+
+// ParseLabelQuotas parses the quotas spec like vip=1000,big=0:1048576,*=100000 in the form of label=maxKeys[:maxBytes].
+func ParseLabelQuotas(spec string) (map[string]LabelQuota, error) {
+	quotas := map[string]LabelQuota{}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		label, limits, ok := strings.Cut(item, "=")
+		if !ok || label == "" {
+			return nil, fmt.Errorf("bad label quota %s, should be label=maxKeys[:maxBytes]", item)
+		}
+
+		var q LabelQuota
+		keys, bytes, _ := strings.Cut(limits, ":")
+		var err error
+		if q.MaxKeys, err = strconv.ParseUint(keys, 10, 64); err != nil {
+			return nil, fmt.Errorf("bad max keys of label quota %s: %w", item, err)
+		}
+		if bytes != "" {
+			if q.MaxBytes, err = strconv.ParseUint(bytes, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad max bytes of label quota %s: %w", item, err)
+			}
+		}
+		quotas[label] = q
+	}
+
+	return quotas, nil
+}
+
+// LabelUsage is the storage usage of a label.
+type LabelUsage struct {
+	Keys  uint64 `json:"keys"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// quotaTracker tracks the usage of a label, and stops writing the keys beyond its quota.
+// It is shared by all the loads of the label, so the concurrent loads can not exceed the quota together.
+type quotaTracker struct {
+	sync.Mutex
+	label string
+	quota LabelQuota
+	usage LabelUsage
+}
+
+// quotaTrackers are the shared quota trackers by label.
+type quotaTrackers struct {
+	sync.Mutex
+	trackers map[string]*quotaTracker
+}
+
+// quotaTracker returns the shared quotaTracker of the label, or nil if the label has no quota.
+// The usage is restored from the meta when the tracker is created.
+func (s *pebbleDB) quotaTracker(label string) *quotaTracker {
+	q, ok := LabelQuotas[label]
+	if !ok {
+		if q, ok = LabelQuotas["*"]; !ok {
+			return nil
+		}
+	}
+	if q.MaxKeys == 0 && q.MaxBytes == 0 {
+		return nil
+	}
+
+	s.quotas.Lock()
+	defer s.quotas.Unlock()
+
+	if t, ok := s.quotas.trackers[label]; ok {
+		return t
+	}
+	if s.quotas.trackers == nil {
+		s.quotas.trackers = map[string]*quotaTracker{}
+	}
+	t := &quotaTracker{label: label, quota: q, usage: s.meta.labelUsage(label)}
+	s.quotas.trackers[label] = t
+	return t
+}
+
+// admit tells whether the key of mobile+label can be written within the quota.
+// Overwriting an existing key is always admitted, because it takes no more keys,
+// but a duplicate key still queued in the op channel is counted again.
+func (t *quotaTracker) admit(s *pebbleDB, mobile, label, value []byte) bool {
+	if ok, err := s.Contains(mobile, label); err == nil && ok {
+		return true
+	}
+
+	size := uint64(len(mobile) + len(label) + len(value))
+	t.Lock()
+	defer t.Unlock()
+
+	if t.quota.MaxKeys > 0 && t.usage.Keys+1 > t.quota.MaxKeys ||
+		t.quota.MaxBytes > 0 && t.usage.Bytes+size > t.quota.MaxBytes {
+		return false
+	}
+
+	t.usage.Keys++
+	t.usage.Bytes += size
+	return true
+}
+
+// release subtracts the removed key of the size from the usage.
+func (t *quotaTracker) release(size uint64) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.usage.Keys > 0 {
+		t.usage.Keys--
+	}
+	if size > t.usage.Bytes {
+		size = t.usage.Bytes
+	}
+	t.usage.Bytes -= size
+}
+
+// releaseQuota subtracts the removed key of mobile+label from the usage of the label quota, and persists the usage.
+// value is the stored value of the key, whose write sequence is not counted, like admit.
+func (s *pebbleDB) releaseQuota(mobile, label, value []byte) error {
+	t := s.quotaTracker(string(label))
+	if t == nil {
+		return nil
+	}
+	v := DecodeLabelValue(value)
+	v.Seq = 0
+	t.release(uint64(len(mobile) + len(label) + len(v.Encode())))
+	return t.save(&s.meta)
+}
+
+// errQuotaExceeded is the error of the single write beyond the quota of the label.
+func errQuotaExceeded(label string) error {
+	return &StatusError{Code: http.StatusForbidden, Err: fmt.Errorf("quota of label %s exceeded", label)}
+}
+
+// save persists the usage to the meta, holding the lock so that the saves of the concurrent loads are in order.
+func (t *quotaTracker) save(m *metaStore) error {
+	t.Lock()
+	defer t.Unlock()
+
+	return m.setLabelUsage(t.label, t.usage)
+}
+
+// report reports the quota, the usage and the overflow, the keys dropped by the load.
+func (t *quotaTracker) report(overflow uint64) H {
+	t.Lock()
+	defer t.Unlock()
+
+	return H{"quota": t.quota, "usage": t.usage, "overflow": overflow}
+}
+
+// QuotaWarnRatio is the ratio of the quota used, above which the loads warn the quota is near the limit.
+const QuotaWarnRatio = 0.9
+
+// warnings returns the warnings of the overflow of the load or the quota near the limit.
+func (t *quotaTracker) warnings(overflow uint64) (warnings []string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if overflow > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d keys are dropped by the quota of label %s", overflow, t.label))
+	} else if t.quota.MaxKeys > 0 && float64(t.usage.Keys) >= QuotaWarnRatio*float64(t.quota.MaxKeys) {
+		warnings = append(warnings, fmt.Sprintf("label %s used %d of the %d keys quota", t.label, t.usage.Keys, t.quota.MaxKeys))
+	} else if t.quota.MaxBytes > 0 && float64(t.usage.Bytes) >= QuotaWarnRatio*float64(t.quota.MaxBytes) {
+		warnings = append(warnings, fmt.Sprintf("label %s used %d of the %d bytes quota", t.label, t.usage.Bytes, t.quota.MaxBytes))
+	}
+	return warnings
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// quotaLoadResult is the load response with the quota report.
+type quotaLoadResult struct {
+	loadResult
+	Quota struct {
+		Usage    LabelUsage `json:"usage"`
+		Overflow uint64     `json:"overflow"`
+	} `json:"quota"`
+}
+
+// mobilesFile returns the content of n mobiles from the first one.
+func mobilesFile(first, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d\n", first+i)
+	}
+	return b.String()
+}
+
+// countLabeled counts the mobiles with the label among the n mobiles from the first one.
+func countLabeled(t *testing.T, db *pebbleDB, first, n int, label string) (count int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		for _, l := range labelsOf(t, db, fmt.Sprint(first+i)) {
+			if l == label {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestLoadBeyondQuota(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"vip": {MaxKeys: 3}})
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 5))
+	writeTestFile(t, "b.txt", mobilesFile(13900000001, 2))
+
+	var res quotaLoadResult
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/vip", "", &res)
+	db.Barrier()
+	if res.Written != 3 || res.Quota.Overflow != 2 || res.Quota.Usage.Keys != 3 || len(res.Warnings) == 0 {
+		t.Errorf("load result %+v, want 3 written and 2 overflow with a warning", res)
+	}
+	if n := countLabeled(t, db, 13800000001, 5, "vip"); n != 3 {
+		t.Errorf("%d mobiles labeled, want 3 of the quota", n)
+	}
+
+	// the usage is restored from the meta after reopen, so no more keys are written.
+	db = reopenTestDB(t, db)
+	if res := load(t, db, "b.txt", "vip", ""); res.Written != 0 {
+		t.Errorf("written %d beyond the used up quota, want 0", res.Written)
+	}
+	// reloading the existing keys is admitted.
+	if res := load(t, db, "a.txt", "vip", ""); res.Written != 3 {
+		t.Errorf("written %d of the reload, want the 3 existing keys", res.Written)
+	}
+	// the other labels are unlimited.
+	if res := load(t, db, "b.txt", "big", ""); res.Written != 2 {
+		t.Errorf("written %d of the label without quota, want 2", res.Written)
+	}
+}
+
+func TestConcurrentLoadsShareQuota(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"*": {MaxKeys: 10}})
+	db := openTestDB(t, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		file := fmt.Sprintf("%d.txt", i)
+		writeTestFile(t, file, mobilesFile(13800000000+i*100, 5))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request(t, db, http.MethodPost, "/load/"+file+"/vip", "", nil)
+		}()
+	}
+	wg.Wait()
+	db.Barrier()
+
+	var n int
+	for i := 0; i < 4; i++ {
+		n += countLabeled(t, db, 13800000000+i*100, 5, "vip")
+	}
+	if n != 10 {
+		t.Errorf("%d mobiles labeled by the concurrent loads, want 10 of the shared quota", n)
+	}
+}
+
+func TestQuotaOfRecordLabels(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"big": {MaxKeys: 1}})
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.ndjson", `{"mobile":13800000001,"label":"big"}`+"\n"+`{"mobile":13800000002,"label":"big"}`+"\n"+`{"mobile":13800000003}`+"\n")
+
+	// the label of the records is limited by its own quota, not the quota of the label in the url.
+	res := load(t, db, "a.ndjson", "vip", "format=ndjson")
+	if res.Written != 2 || res.Skipped != 1 || len(res.Warnings) == 0 {
+		t.Errorf("load result %+v, want 2 written and 1 skipped by the quota of big", res)
+	}
+	if n := countLabeled(t, db, 13800000001, 2, "big"); n != 1 {
+		t.Errorf("%d mobiles labeled big, want 1 of the quota", n)
+	}
+
+	// the single writes are limited too.
+	if code, errMsg := request(t, db, http.MethodPut, "/labels/13900000001/big", "", nil); code != http.StatusForbidden {
+		t.Errorf("put beyond the quota: %d %s, want 403", code, errMsg)
+	}
+}
+
+func TestQuotaReleasedByRemove(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"vip": {MaxKeys: 2}})
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 2))
+	load(t, db, "a.txt", "vip", "")
+
+	if code, _ := request(t, db, http.MethodPut, "/labels/13900000001/vip", "", nil); code != http.StatusForbidden {
+		t.Fatalf("put beyond the quota: %d, want 403", code)
+	}
+	// the purged and removed keys release the usage for the new keys.
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000001", "", nil)
+	mustRequest(t, db, http.MethodDelete, "/labels/13800000002/vip", "", nil)
+	mustRequest(t, db, http.MethodPut, "/labels/13900000001/vip", "", nil)
+	mustRequest(t, db, http.MethodPut, "/labels/13900000002/vip", "", nil)
+	db.Barrier()
+	if n := countLabeled(t, db, 13900000001, 2, "vip"); n != 2 {
+		t.Errorf("%d mobiles labeled vip after the release, want 2", n)
+	}
+	if usage := db.meta.labelUsage("vip"); usage.Keys != 2 {
+		t.Errorf("usage %+v, want 2 keys", usage)
+	}
+}
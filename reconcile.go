@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ReconcileDiff is the discrepancy of a mobile between the expected labels and the stored ones.
+type ReconcileDiff struct {
+	Mobile string `json:"mobile"`
+	// Missing are the expected labels not in the store.
+	Missing []string `json:"missing,omitempty"`
+	// Extra are the stored labels not expected.
+	Extra []string `json:"extra,omitempty"`
+}
+
+// Reconcile compares the labels of the mobiles in the store against the expected ones, and reports the discrepancies.
+//
+// The expected labels are in the lines of mobile,label[,label...] like 13800138000,vip,big, the lines of the same mobile
+// are merged. They are read from the request body, or the file of the query param file on the server.
+// The diffs are limited by the query param limit (default 1000), while the counts cover all the mobiles.
+func (s *pebbleDB) Reconcile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	start := time.Now()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+	limit := 1000
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			return err
+		}
+	}
+
+	var in io.Reader = r.Body
+	source := "body"
+	if file := r.URL.Query().Get("file"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in, source = f, file
+	}
+
+	var mobiles []string
+	expected := map[string]map[string]bool{}
+	if err := scanReader(source, in, func(line string) error {
+		fields := strings.Split(line, ",")
+		mobile, err := mobile2bytes(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return err
+		}
+		m := bytes2mobile(mobile)
+		labels, ok := expected[m]
+		if !ok {
+			labels = map[string]bool{}
+			expected[m] = labels
+			mobiles = append(mobiles, m)
+		}
+		for _, l := range fields[1:] {
+			if l = strings.TrimSpace(l); l != "" {
+				labels[NormalizeLabel(l)] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	diffs := []ReconcileDiff{}
+	var matched, mismatched, absent int
+	for _, m := range mobiles {
+		mobile, _ := mobile2bytes(m)
+		stored, err := s.FindLabelsByMobile(ds.key(mobile))
+		if err != nil {
+			return err
+		}
+
+		diff := diffLabels(m, expected[m], stored)
+		if diff.Missing == nil && diff.Extra == nil {
+			matched++
+			continue
+		}
+		mismatched++
+		if len(stored) == 0 {
+			absent++
+		}
+		if len(diffs) < limit {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	cost := time.Since(start)
+	logAt(LevelInfo, "reconcile %s: %d mobiles, %d matched, %d mismatched, cost %s", source, len(mobiles), matched, mismatched, cost)
+	return jsonResponse(w, H{"cost": cost.String(), "mobiles": len(mobiles), "matched": matched,
+		"mismatched": mismatched, "absent": absent, "truncated": mismatched > len(diffs), "diffs": diffs})
+}
+
+// diffLabels diffs the expected labels of the mobile against the stored ones.
+func diffLabels(mobile string, expected map[string]bool, stored []string) ReconcileDiff {
+	diff := ReconcileDiff{Mobile: mobile}
+	found := map[string]bool{}
+	for _, l := range stored {
+		found[l] = true
+		if !expected[l] {
+			diff.Extra = append(diff.Extra, l)
+		}
+	}
+	for l := range expected {
+		if !found[l] {
+			diff.Missing = append(diff.Missing, l)
+		}
+	}
+	sort.Strings(diff.Missing)
+	return diff
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// reconcileResult is the response body of the reconcile.
+type reconcileResult struct {
+	Mobiles    int             `json:"mobiles"`
+	Matched    int             `json:"matched"`
+	Mismatched int             `json:"mismatched"`
+	Absent     int             `json:"absent"`
+	Truncated  bool            `json:"truncated"`
+	Diffs      []ReconcileDiff `json:"diffs"`
+}
+
+func TestReconcile(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 3))
+	writeTestFile(t, "b.txt", "13800000002\n")
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "b.txt", "big", "")
+
+	expected := strings.Join([]string{
+		"13800000001,vip",
+		"13800000002,vip",
+		"13800000003, vip ,new",
+		"13800000004,vip",
+		"13800000003,old",
+	}, "\n")
+	var res reconcileResult
+	mustRequest(t, db, http.MethodPost, "/reconcile", expected, &res)
+	if res.Mobiles != 4 || res.Matched != 1 || res.Mismatched != 3 || res.Absent != 1 || res.Truncated {
+		t.Errorf("reconcile %+v, want 4 mobiles, 1 matched, 3 mismatched of 1 absent", res)
+	}
+	want := []ReconcileDiff{
+		{Mobile: "13800000002", Extra: []string{"big"}},
+		{Mobile: "13800000003", Missing: []string{"new", "old"}},
+		{Mobile: "13800000004", Missing: []string{"vip"}},
+	}
+	if len(res.Diffs) != len(want) {
+		t.Fatalf("diffs %+v, want %+v", res.Diffs, want)
+	}
+	for i, d := range res.Diffs {
+		if d.Mobile != want[i].Mobile || strings.Join(d.Missing, ",") != strings.Join(want[i].Missing, ",") ||
+			strings.Join(d.Extra, ",") != strings.Join(want[i].Extra, ",") {
+			t.Errorf("diff %d is %+v, want %+v", i, d, want[i])
+		}
+	}
+
+	// the expected labels from the file on the server, with the diffs limited.
+	writeTestFile(t, "expected.csv", expected)
+	res = reconcileResult{}
+	mustRequest(t, db, http.MethodPost, "/reconcile?file=expected.csv&limit=1", "", &res)
+	if res.Mismatched != 3 || len(res.Diffs) != 1 || !res.Truncated {
+		t.Errorf("reconcile %+v, want 3 mismatched truncated to 1 diff", res)
+	}
+
+	if code, _ := request(t, db, http.MethodPost, "/reconcile", "x,vip", nil); code != http.StatusBadRequest {
+		t.Errorf("reconcile of the bad mobile: status %d, want 400", code)
+	}
+}
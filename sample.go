@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// SampleMobiles returns n approximately uniform random mobiles with the label, by reservoir sampling.
+// The same seed gives the same sample on the same data.
+func (s *pebbleDB) SampleMobiles(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+	start := time.Now()
+	label := NormalizeLabel(p.ByName("label"))
+	q := r.URL.Query()
+	ds, err := datasetOf(r)
+	if err != nil {
+		return err
+	}
+
+	n := 10
+	if v := q.Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			return err
+		}
+	}
+	seed := time.Now().UnixNano()
+	if v := q.Get("seed"); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return err
+		}
+	}
+
+	sample, total, err := s.Sample(ds, []byte(label), n, seed)
+	if err != nil {
+		return err
+	}
+
+	mobiles := make([]string, len(sample))
+	for i, m := range sample {
+		mobiles[i] = bytes2mobile(m)
+	}
+
+	cost := time.Since(start)
+	return jsonResponse(w, H{"cost": cost.String(), "seed": seed, "total": total, "mobiles": mobiles})
+}
+
+// Sample samples n mobiles of the dataset with the label by the reservoir sampling over all the partitions,
+// returns the sample and the total number of the mobiles with the label.
+// There is no reverse index, so all the forward keys are scanned in the partition and key order.
+func (s *pebbleDB) Sample(ds dataset, label []byte, n int, seed int64) (sample [][]byte, total int, err error) {
+	if n <= 0 {
+		return nil, 0, nil
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	for i := range s.dbs {
+		if err := s.scanPartitionRange(i, ds.iterOptions(), func(batch []KV) error {
+			for _, kv := range batch {
+				mobile, l, ok := ds.split(kv.Key)
+				if !ok || !bytes.Equal(l, label) {
+					continue
+				}
+
+				total++
+				if len(sample) < n {
+					sample = append(sample, mobile)
+				} else if j := rnd.Intn(total); j < n {
+					sample[j] = mobile
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return sample, total, nil
+}
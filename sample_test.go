@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type sampleResult struct {
+	Seed    int64    `json:"seed"`
+	Total   int      `json:"total"`
+	Mobiles []string `json:"mobiles"`
+}
+
+func TestSampleMobiles(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000001, 100))
+	writeTestFile(t, "b.txt", mobilesFile(13900000001, 50))
+	load(t, db, "a.txt", "vip", "")
+	load(t, db, "b.txt", "big", "")
+
+	full := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		full[fmt.Sprint(13800000001+i)] = true
+	}
+
+	var res sampleResult
+	mustRequest(t, db, http.MethodGet, "/mobiles/vip/sample?n=10&seed=42", "", &res)
+	if res.Total != 100 || len(res.Mobiles) != 10 {
+		t.Fatalf("sampled %d of %d mobiles, want 10 of 100", len(res.Mobiles), res.Total)
+	}
+	seen := map[string]bool{}
+	for _, m := range res.Mobiles {
+		if !full[m] || seen[m] {
+			t.Errorf("sampled mobile %s is not a distinct one of the vip set", m)
+		}
+		seen[m] = true
+	}
+
+	// the same seed gives the same sample.
+	var again sampleResult
+	mustRequest(t, db, http.MethodGet, "/mobiles/vip/sample?n=10&seed=42", "", &again)
+	if fmt.Sprint(again.Mobiles) != fmt.Sprint(res.Mobiles) {
+		t.Errorf("sample %v of the same seed, want %v", again.Mobiles, res.Mobiles)
+	}
+
+	// the whole set is returned when n exceeds it.
+	mustRequest(t, db, http.MethodGet, "/mobiles/big/sample?n=80", "", &res)
+	if res.Total != 50 || len(res.Mobiles) != 50 {
+		t.Errorf("sampled %d of %d mobiles, want all the 50", len(res.Mobiles), res.Total)
+	}
+}
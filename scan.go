@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ScanBatchSize is the number of keys per batch in full scans.
+var ScanBatchSize = 1024
+
+// KV is a key value pair copied out of the iterator.
+type KV struct {
+	Key, Value []byte
+}
+
+// scanBatches scans the db in the key range of opts (nil for all), copying the keys in batches of ScanBatchSize.
+// The iteration runs in its own goroutine, pipelined with fn processing the previous batch,
+// so that the disk reads are not stalled by the processing.
+// Pebble does the read-ahead for the sequential reads itself, there is no option for it in the IterOptions.
+func scanBatches(db *pebble.DB, opts *pebble.IterOptions, fn func(batch []KV) error) error {
+	batchSize := ScanBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	batches := make(chan []KV, 2)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopIter := func() { stopOnce.Do(func() { close(stop) }) }
+	// stop the iteration even on panic of fn, so that the iterator is closed.
+	defer stopIter()
+	iterErr := make(chan error, 1)
+
+	go func() {
+		defer close(batches)
+
+		iter := db.NewIter(opts)
+		batch := make([]KV, 0, batchSize)
+		for iter.First(); iter.Valid(); iter.Next() {
+			batch = append(batch, KV{
+				Key:   append([]byte(nil), iter.Key()...),
+				Value: append([]byte(nil), iter.Value()...),
+			})
+			if len(batch) == batchSize {
+				select {
+				case batches <- batch:
+				case <-stop:
+					iterErr <- iter.Close()
+					return
+				}
+				batch = make([]KV, 0, batchSize)
+			}
+		}
+		if len(batch) > 0 {
+			select {
+			case batches <- batch:
+			case <-stop:
+			}
+		}
+		iterErr <- iter.Close()
+	}()
+
+	var err error
+	for batch := range batches {
+		if err == nil {
+			if err = fn(batch); err != nil {
+				stopIter()
+			}
+		}
+	}
+
+	if closeErr := <-iterErr; err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// openSyntheticPartition opens a partition of n synthetic mobile+label keys.
+func openSyntheticPartition(tb testing.TB, n int) *pebble.DB {
+	tb.Helper()
+	db, err := pebble.Open(filepath.Join(tb.TempDir(), "db"), &pebble.Options{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	b := db.NewBatch()
+	for i := 0; i < n; i++ {
+		key := binary.LittleEndian.AppendUint64(nil, 13800000000+uint64(i))
+		if err := b.Set(append(key, "label"...), []byte("batch=1&seq=1"), nil); err != nil {
+			tb.Fatal(err)
+		}
+		if b.Len() > 4<<20 {
+			if err := b.Commit(pebble.NoSync); err != nil {
+				tb.Fatal(err)
+			}
+			b = db.NewBatch()
+		}
+	}
+	if err := b.Commit(pebble.NoSync); err != nil {
+		tb.Fatal(err)
+	}
+	// scans the tables on disk, instead of the memtable.
+	if err := db.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func TestScanBatches(t *testing.T) {
+	db := openSyntheticPartition(t, 1000)
+	setGlobal(t, &ScanBatchSize, 64)
+
+	var keys, batches int
+	var last []byte
+	if err := scanBatches(db, nil, func(batch []KV) error {
+		batches++
+		for _, kv := range batch {
+			if last != nil && string(kv.Key) <= string(last) {
+				t.Fatalf("key %x is not after %x", kv.Key, last)
+			}
+			last = kv.Key
+			keys++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if keys != 1000 || batches != 16 {
+		t.Errorf("scanned %d keys in %d batches, want 1000 in 16", keys, batches)
+	}
+
+	// the error of fn stops the scan.
+	errStop := errors.New("stop")
+	batches = 0
+	if err := scanBatches(db, nil, func(batch []KV) error {
+		batches++
+		return errStop
+	}); err != errStop || batches != 1 {
+		t.Errorf("scan stopped by error %v after %d batches, want %v after 1", err, batches, errStop)
+	}
+}
+
+// BenchmarkScan compares the key by key iteration with the pipelined batches of the sizes.
+func BenchmarkScan(b *testing.B) {
+	const n = 200000
+	db := openSyntheticPartition(b, n)
+
+	b.Run("iterator", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			iter := db.NewIter(nil)
+			var size int
+			for iter.First(); iter.Valid(); iter.Next() {
+				size += len(iter.Key()) + len(iter.Value())
+			}
+			if err := iter.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, batchSize := range []int{1, 1024, 16384} {
+		b.Run(fmt.Sprintf("batch-%d", batchSize), func(b *testing.B) {
+			setGlobal(b, &ScanBatchSize, batchSize)
+			for i := 0; i < b.N; i++ {
+				var size int
+				if err := scanBatches(db, nil, func(batch []KV) error {
+					for _, kv := range batch {
+						size += len(kv.Key) + len(kv.Value)
+					}
+					return nil
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
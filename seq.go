@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// WriteSeq stamps the labels with a per-partition write sequence in their values, returned by the queries with with_seq=true.
+//
+// The pebble sequence numbers are internal and not exposed by the iterators, so the sequence is a counter of our own,
+// assigned by the writer goroutine of the partition in the order the writes are applied. Its limitations:
+//   - it is only comparable between the labels of the same partition, e.g. the labels of the same mobile;
+//   - it is seeded by the wall clock at Open, so it keeps increasing across restarts only if the clock does not go back;
+//   - the labels written before it is enabled, by the bulk loads or by the admin writes have no sequence.
+var WriteSeq bool
+
+// newWriteSeqs seeds the write sequences of the partitions.
+func newWriteSeqs(partitions uint64) []uint64 {
+	seqs := make([]uint64, partitions)
+	seed := uint64(time.Now().UnixNano())
+	for i := range seqs {
+		seqs[i] = seed
+	}
+	return seqs
+}
+
+// stampSeq appends the next write sequence of the partition to the url query encoded value of the op.
+// It is called only by the writer goroutine of the partition.
+func (s *pebbleDB) stampSeq(k op) []byte {
+	if !k.stamp {
+		return k.value
+	}
+
+	s.seqs[k.partition]++
+	value := append([]byte{}, k.value...)
+	if len(value) > 0 {
+		value = append(value, '&')
+	}
+	value = append(value, "seq="...)
+	return strconv.AppendUint(value, s.seqs[k.partition], 10)
+}
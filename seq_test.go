@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWriteSeq(t *testing.T) {
+	setGlobal(t, &WriteSeq, true)
+	db := openTestDB(t, 4)
+
+	// the labels are listed by name, written in another order.
+	for _, label := range []string{"c", "a", "b"} {
+		mustRequest(t, db, http.MethodPut, "/labels/13800000001/"+label, "", nil)
+		db.Barrier()
+	}
+	seqs := func() map[string]uint64 {
+		t.Helper()
+		var res struct {
+			Labels []LabelEntry `json:"labels"`
+		}
+		mustRequest(t, db, http.MethodGet, "/labels/13800000001?with_seq=true", "", &res)
+		m := map[string]uint64{}
+		for _, e := range res.Labels {
+			m[e.Label] = e.Seq
+		}
+		return m
+	}
+	got := seqs()
+	if len(got) != 3 || got["c"] == 0 || !(got["c"] < got["a"] && got["a"] < got["b"]) {
+		t.Fatalf("seqs %v, want increasing by the writes of c, a and b", got)
+	}
+
+	// the re-write of a label takes the next seq.
+	mustRequest(t, db, http.MethodPut, "/labels/13800000001/c", "", nil)
+	db.Barrier()
+	if again := seqs(); again["c"] <= got["b"] {
+		t.Errorf("seq of the re-written c is %d, want above %d of b", again["c"], got["b"])
+	}
+
+	// the seqs are returned only by with_seq.
+	var res struct {
+		Labels []LabelEntry `json:"labels"`
+	}
+	mustRequest(t, db, http.MethodGet, "/labels/13800000001?with_source=y", "", &res)
+	for _, e := range res.Labels {
+		if e.Seq != 0 {
+			t.Errorf("label %s has seq %d without with_seq", e.Label, e.Seq)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// StreamAckInterval is the interval to ack the number of persisted records to the stream producer.
+var StreamAckInterval = time.Second
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 16 * 1024}
+
+// StreamAck is the ack message sent to the stream producer.
+type StreamAck struct {
+	Persisted uint64 `json:"persisted"`
+	Rejected  uint64 `json:"rejected"`
+	// Skipped are the blank, comment or over quota records.
+	Skipped uint64 `json:"skipped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// LoadStream accepts a stream of newline or JSON delimited records over websocket,
+// and appends them with the label, acking periodically how many records were persisted.
+// The records are written like the lines of LoadFile, with its transform and comment params, collision policy and quota.
+func (s *pebbleDB) LoadStream(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	label := NormalizeLabel(p.ByName("label"))
+	if err := s.checkWritable(); err != nil {
+		jsonResponseError(w, err)
+		return
+	}
+	l, err := s.newLineLoader(r, label, "")
+	if err != nil {
+		jsonResponseError(w, err)
+		return
+	}
+	// the lines starting with { are the JSON records, whose label overrides the label in the url.
+	l.ndjson, l.mixed = &NDJSONFields{Mobile: "mobile", Label: "label"}, true
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logAt(LevelWarn, "upgrade websocket failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	logAt(LevelInfo, "start to load stream from %s with label: %s", r.RemoteAddr, label)
+	start := time.Now()
+	var lastError atomic.Value
+	// rejects signals the ack loop to ack the rejected record at once, instead of on the next tick.
+	rejects := make(chan struct{}, 1)
+	l.onReject = func(err error) {
+		lastError.Store(err.Error())
+		select {
+		case rejects <- struct{}{}:
+		default:
+		}
+	}
+	lineCallback := l.callback()
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				// flush the buffered ops on disconnect, so all the received records are persisted.
+				l.barrier()
+				if err := l.saveQuotas(); err != nil {
+					logAt(LevelError, "save quotas of stream from %s failed: %v", r.RemoteAddr, err)
+				}
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					logAt(LevelWarn, "read stream from %s failed: %v", r.RemoteAddr, err)
+				}
+				return
+			}
+
+			sc := bufio.NewScanner(bytes.NewReader(message))
+			for sc.Scan() {
+				// unlike the files, a failed record does not end the stream.
+				if err := lineCallback(string(bytes.TrimSpace(sc.Bytes()))); err != nil {
+					l.reject(err)
+				}
+			}
+		}
+	}()
+
+	// the acks are sent by the ticker, so an idle producer gets the ack of its last records too,
+	// and only this goroutine writes to the connection.
+	ticker := time.NewTicker(StreamAckInterval)
+	defer ticker.Stop()
+	sendAck := func() error {
+		ack := StreamAck{Persisted: l.written.Load(), Rejected: l.rejected.Load(), Skipped: l.skipped.Load()}
+		if err, ok := lastError.Swap("").(string); ok {
+			ack.Error = err
+		}
+		l.barrier()
+		return conn.WriteJSON(ack)
+	}
+
+loop:
+	for {
+		select {
+		case <-closed:
+			break loop
+		case <-ticker.C:
+		case <-rejects:
+		}
+		if err := sendAck(); err != nil {
+			logAt(LevelWarn, "ack stream to %s failed: %v", r.RemoteAddr, err)
+			conn.Close()
+			<-closed
+			break
+		}
+	}
+
+	logAt(LevelInfo, "load stream from %s with label: %s, records: %d, rejected: %d, cost %s",
+		r.RemoteAddr, label, l.written.Load(), l.rejected.Load(), time.Since(start))
+}
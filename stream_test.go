@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLoadStream(t *testing.T) {
+	db := openTestDB(t, 4)
+	setGlobal(t, &StreamAckInterval, 10*time.Millisecond)
+	srv := httptest.NewServer(newRouter(db))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/stream/vip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	messages := []string{
+		"13800000001\n13800000002\n\n",
+		`{"mobile":13800000003,"label":"big"}`,
+		"abc",
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the acks come by the ticker, until all the records are acked.
+	var ack StreamAck
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for ack.Persisted+ack.Rejected < 4 {
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the blank line is skipped.
+	if ack.Persisted != 3 || ack.Rejected != 1 {
+		t.Errorf("ack %+v, want 3 persisted and 1 rejected", ack)
+	}
+
+	// closes the stream and waits for the server to flush and hang up, before the db is closed.
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	for m, want := range map[string]string{"13800000001": "vip", "13800000002": "vip", "13800000003": "big"} {
+		if got := labelsOf(t, db, m); len(got) != 1 || got[0] != want {
+			t.Errorf("labels of %s are %v, want [%s]", m, got, want)
+		}
+	}
+}
+
+func TestLoadStreamLikeFile(t *testing.T) {
+	db := openTestDB(t, 4)
+	setGlobal(t, &StreamAckInterval, 10*time.Millisecond)
+	srv := httptest.NewServer(newRouter(db))
+	defer srv.Close()
+
+	query := "?comment=" + url.QueryEscape("#") + "&transform=" + url.QueryEscape("strip-prefix:86")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/stream/vip"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	message := "# the header\n8613800000001\n" + `{"mobile":"8613800000002","label":"big"}` + "\n{bad"
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+
+	var ack StreamAck
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for ack.Persisted+ack.Rejected+ack.Skipped < 4 {
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ack.Persisted != 2 || ack.Rejected != 1 || ack.Skipped != 1 {
+		t.Errorf("ack %+v, want 2 persisted, 1 rejected and 1 skipped", ack)
+	}
+
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	for m, want := range map[string]string{"13800000001": "vip", "13800000002": "big"} {
+		if got := labelsOf(t, db, m); len(got) != 1 || got[0] != want {
+			t.Errorf("labels of %s are %v, want [%s]", m, got, want)
+		}
+	}
+}
+
+func TestLoadStreamBeyondQuota(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &LabelQuotas, map[string]LabelQuota{"vip": {MaxKeys: 2}})
+	db := openTestDB(t, 4)
+	setGlobal(t, &StreamAckInterval, 10*time.Millisecond)
+	srv := httptest.NewServer(newRouter(db))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/stream/vip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("13800000001\n13800000002\n13800000003\n")); err != nil {
+		t.Fatal(err)
+	}
+	var ack StreamAck
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for ack.Persisted+ack.Rejected+ack.Skipped < 3 {
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ack.Persisted != 2 || ack.Skipped != 1 {
+		t.Errorf("ack %+v, want 2 persisted and 1 skipped by the quota", ack)
+	}
+
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if usage := db.meta.labelUsage("vip"); usage.Keys != 2 {
+		t.Errorf("usage %+v saved on disconnect, want 2 keys", usage)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IngestRate is the max rate of the lines (or bytes) fed to the loads, to keep a big load
+// from starving the disk I/O of the other tenants, zero for unlimited.
+var IngestRate Rate
+
+// Rate is a rate of lines or bytes per second.
+type Rate struct {
+	PerSecond float64
+	Bytes     bool
+}
+
+// ParseRate parses a rate like 5000 or 5000/s for lines per second, 512KB or 10MB/s for bytes per second.
+func ParseRate(v string) (Rate, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "/S")
+	var r Rate
+	scale := 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale, r.Bytes = strings.TrimSuffix(s, u.suffix), u.scale, true
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("bad rate %q, expect like 5000/s for lines or 10MB/s for bytes", v)
+	}
+	r.PerSecond = n * scale
+	return r, nil
+}
+
+func (r Rate) unit() string {
+	if r.Bytes {
+		return "bytes/s"
+	}
+	return "lines/s"
+}
+
+func (r Rate) String() string {
+	if r.PerSecond <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.0f %s", r.PerSecond, r.unit())
+}
+
+// throttleNow and throttleSleep are the clock of the token buckets, replaced by the tests.
+var (
+	throttleNow   = time.Now
+	throttleSleep = time.Sleep
+)
+
+// tokenBucket is a token bucket refilled at rate tokens per second, holding at most a second of tokens.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: throttleNow()}
+}
+
+// wait takes n tokens, sleeping until they are refilled when the bucket runs short.
+// The tokens are reserved before sleeping, so concurrent waiters queue up fairly.
+func (b *tokenBucket) wait(n float64) {
+	b.Lock()
+	now := throttleNow()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= n
+	short := b.tokens
+	b.Unlock()
+
+	if short < 0 {
+		throttleSleep(time.Duration(-short / b.rate * float64(time.Second)))
+	}
+}
+
+// ingestThrottle is the token bucket shared by all the loads of the db,
+// so that the concurrent loads together are fed no faster than the rate.
+type ingestThrottle struct {
+	sync.Mutex
+	rate   Rate
+	bucket *tokenBucket
+}
+
+// throttle wraps the line callback to feed it no faster than the rate, as is when unlimited.
+// The bucket is renewed when the rate is changed.
+func (t *ingestThrottle) throttle(r Rate, lineCallback func(line string) error) func(line string) error {
+	if r.PerSecond <= 0 {
+		return lineCallback
+	}
+
+	t.Lock()
+	if t.bucket == nil || t.rate != r {
+		t.rate, t.bucket = r, newTokenBucket(r.PerSecond)
+	}
+	bucket := t.bucket
+	t.Unlock()
+	return func(line string) error {
+		if r.Bytes {
+			bucket.wait(float64(len(line) + 1)) // +1 for the line break
+		} else {
+			bucket.wait(1)
+		}
+		return lineCallback(line)
+	}
+}
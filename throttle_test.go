@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		v    string
+		want Rate
+	}{
+		{"5000", Rate{PerSecond: 5000}},
+		{"5000/s", Rate{PerSecond: 5000}},
+		{"512KB", Rate{PerSecond: 512 << 10, Bytes: true}},
+		{"10mb/s", Rate{PerSecond: 10 << 20, Bytes: true}},
+		{"100B/s", Rate{PerSecond: 100, Bytes: true}},
+		{"0", Rate{}},
+	}
+	for _, c := range cases {
+		if got, err := ParseRate(c.v); err != nil || got != c.want {
+			t.Errorf("parse rate %q = %+v, %v, want %+v", c.v, got, err, c.want)
+		}
+	}
+	for _, v := range []string{"", "fast", "-1", "10TB"} {
+		if _, err := ParseRate(v); err == nil {
+			t.Errorf("parse rate %q should fail", v)
+		}
+	}
+}
+
+// fakeClock is the clock of the token buckets, advanced only by the sleeps.
+type fakeClock struct {
+	sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+// sleptSince returns the time slept since the last call.
+func (c *fakeClock) sleptSince() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	d := c.slept
+	c.slept = 0
+	return d
+}
+
+func TestLoadWithIngestRate(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", mobilesFile(13800000000, 1500))
+	clock := &fakeClock{now: time.Now()}
+	setGlobal(t, &throttleNow, clock.Now)
+	setGlobal(t, &throttleSleep, clock.Sleep)
+
+	// the bucket holds a second of tokens at first, the rest 500 lines wait half a second.
+	setGlobal(t, &IngestRate, Rate{PerSecond: 1000})
+	var res struct {
+		loadResult
+		IngestRate struct {
+			Limit     string `json:"limit"`
+			Effective string `json:"effective"`
+		} `json:"ingest_rate"`
+	}
+	// a single worker, so the sleeps of the clock are not overlapped.
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/vip?workers=1", "", &res)
+	if slept := clock.sleptSince(); slept < 490*time.Millisecond || slept > 510*time.Millisecond {
+		t.Errorf("the rate-limited load of 1500 lines waited %s, want 500ms", slept)
+	}
+	if res.Written != 1500 || res.IngestRate.Limit != "1000 lines/s" || res.IngestRate.Effective == "" {
+		t.Errorf("load result %+v, want 1500 written with the ingest rate", res)
+	}
+
+	// the bucket is shared by the loads, the next load starts with the tokens used up by the last one.
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/big?workers=1", "", &res)
+	if slept := clock.sleptSince(); slept < 1490*time.Millisecond || slept > 1510*time.Millisecond {
+		t.Errorf("the next load of 1500 lines waited %s, want 1.5s of the shared bucket", slept)
+	}
+
+	// the unlimited load reports no ingest rate.
+	setGlobal(t, &IngestRate, Rate{})
+	res.IngestRate.Limit = ""
+	mustRequest(t, db, http.MethodPost, "/load/a.txt/big", "", &res)
+	if res.IngestRate.Limit != "" {
+		t.Errorf("ingest rate %+v of the unlimited load, want none", res.IngestRate)
+	}
+}
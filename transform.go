@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Transform transforms a parsed token before it is converted to mobile bytes.
+type Transform func(s string) string
+
+// Transforms is a chain of Transform applied in order.
+type Transforms []Transform
+
+// Apply applies the transforms in order.
+func (t Transforms) Apply(s string) string {
+	for _, f := range t {
+		s = f(s)
+	}
+	return s
+}
+
+type regexReplace struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// regexReplaces is the fixed set of the regex replacements, arbitrary regex is not allowed to keep it safe.
+var regexReplaces = map[string]regexReplace{
+	// non-digits removes all the non-digit characters, like spaces and dashes in 138-0013-8000.
+	"non-digits": {re: regexp.MustCompile(`\D+`), repl: ""},
+	// country-code strips the leading China country code of +86, 0086 or 86 from an 11 digits mobile.
+	"country-code": {re: regexp.MustCompile(`^(?:\+|00)?86(\d{11})$`), repl: "$1"},
+}
+
+// ParseTransforms parses the transforms spec, like strip-prefix:86;pad-left:11:9;regex-replace:non-digits
+// The supported transforms are:
+// 1. strip-prefix:{prefix} strips the prefix if it is present.
+// 2. pad-left:{width}:{char} pads the left with the char to the width, the zeros are rejected,
+// because the leading zeros are lost in the mobile key, see MobileWidth instead.
+// 3. regex-replace:{name} replaces by the named regex in the fixed set, non-digits or country-code.
+func ParseTransforms(spec string) (ts Transforms, err error) {
+	for _, item := range strings.Split(spec, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(item, ":")
+		switch name {
+		case "strip-prefix":
+			if arg == "" {
+				return nil, fmt.Errorf("transform %s requires a prefix", item)
+			}
+			ts = append(ts, func(s string) string { return strings.TrimPrefix(s, arg) })
+		case "pad-left":
+			widthArg, pad, _ := strings.Cut(arg, ":")
+			width, err := strconv.Atoi(widthArg)
+			if err != nil || width <= 0 || width > 20 {
+				return nil, fmt.Errorf("transform %s requires a width in (0, 20]", item)
+			}
+			if len(pad) != 1 {
+				return nil, fmt.Errorf("transform %s requires a single pad char", item)
+			}
+			if pad == "0" {
+				return nil, fmt.Errorf("transform %s pads zeros which are lost in the stored mobile, use -mobile-width to format the mobiles with leading zeros", item)
+			}
+			ts = append(ts, func(s string) string {
+				if n := width - len(s); n > 0 {
+					return strings.Repeat(pad, n) + s
+				}
+				return s
+			})
+		case "regex-replace":
+			r, ok := regexReplaces[arg]
+			if !ok {
+				return nil, fmt.Errorf("transform %s has unknown regex, available: non-digits, country-code", item)
+			}
+			ts = append(ts, func(s string) string { return r.re.ReplaceAllString(s, r.repl) })
+		default:
+			return nil, fmt.Errorf("unknown transform %s", item)
+		}
+	}
+
+	return ts, nil
+}
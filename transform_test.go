@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseTransforms(t *testing.T) {
+	cases := []struct {
+		spec, in, want string
+	}{
+		{"strip-prefix:86", "8613800138000", "13800138000"},
+		{"strip-prefix:86", "13800138000", "13800138000"},
+		{"pad-left:11:9", "3800138000", "93800138000"},
+		{"pad-left:11:9", "13800138000", "13800138000"},
+		{"regex-replace:non-digits", "138-0013-8000", "13800138000"},
+		{"regex-replace:country-code", "+8613800138000", "13800138000"},
+		{"regex-replace:country-code", "008613800138000", "13800138000"},
+		{"regex-replace:country-code", "8613800138000", "13800138000"},
+		{"regex-replace:country-code", "86138001380", "86138001380"},
+		{"regex-replace:non-digits; strip-prefix:0086", "0086-138-0013-8000", "13800138000"},
+		{"", "13800138000", "13800138000"},
+	}
+	for _, c := range cases {
+		ts, err := ParseTransforms(c.spec)
+		if err != nil {
+			t.Errorf("parse %q: %v", c.spec, err)
+			continue
+		}
+		if got := ts.Apply(c.in); got != c.want {
+			t.Errorf("transform %q of %s is %s, want %s", c.spec, c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseTransformsInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"strip-prefix",
+		"pad-left:0",
+		"pad-left:21",
+		"pad-left:11:00",
+		"pad-left:11",
+		"pad-left:11:0",
+		"regex-replace:.*",
+		"eval:1+1",
+	} {
+		if _, err := ParseTransforms(spec); err == nil {
+			t.Errorf("parse %q should fail", spec)
+		}
+	}
+}
+
+func TestLoadWithTransform(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "8613800000001\n13800000002\n")
+
+	load(t, db, "a.txt", "vip", "transform="+url.QueryEscape("strip-prefix:86"))
+	for _, m := range []string{"13800000001", "13800000002"} {
+		if got := labelsOf(t, db, m); len(got) != 1 || got[0] != "vip" {
+			t.Errorf("labels of %s are %v, want [vip]", m, got)
+		}
+	}
+}
+
+func TestLoadWithPadLeftRoundTrip(t *testing.T) {
+	inTempDir(t)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "8600000001\n")
+
+	load(t, db, "a.txt", "vip", "transform="+url.QueryEscape("pad-left:11:1"))
+	var res struct {
+		Mobile string   `json:"mobile"`
+		Labels []string `json:"labels"`
+	}
+	mustRequest(t, db, "GET", "/labels/18600000001", "", &res)
+	if res.Mobile != "18600000001" || len(res.Labels) != 1 || res.Labels[0] != "vip" {
+		t.Errorf("the padded mobile got %s %v, want 18600000001 [vip]", res.Mobile, res.Labels)
+	}
+
+	// the zeros are rejected, instead of being lost silently in the stored mobile.
+	if code, body := request(t, db, "POST", "/load/a.txt/vip?transform="+url.QueryEscape("pad-left:11:0"), "", nil); code != 400 {
+		t.Errorf("load with zero padding: %d %s, want 400", code, body)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeLabels normalizes the labels to Unicode NFC before they become the key suffixes,
+// so that the composed and decomposed forms of the same label map to the same key.
+var NormalizeLabels bool
+
+// NormalizeLabel normalizes the label to NFC if NormalizeLabels is enabled.
+// It should be applied to the labels both on write and on query.
+func NormalizeLabel(label string) string {
+	if NormalizeLabels {
+		return norm.NFC.String(label)
+	}
+	return label
+}
+
+// LabelValue is the metadata stored as the value of a mobile+label key.
+// It is encoded as url query values, so an empty LabelValue is stored as an empty value.
+type LabelValue struct {
+	// Source is the base name of the file which the label was loaded from.
+	Source string
+	// Batch is the batch_id of the load which wrote the label, a re-load of the label under a different batch keeps the latest.
+	Batch string
+	// Seq is the write sequence of the label in its partition, see WriteSeq.
+	Seq uint64
+	// Sum is the checksum of the mobile string which the key was written from, see CollisionPolicy.
+	Sum string
+}
+
+// Encode encodes the LabelValue to bytes.
+func (v LabelValue) Encode() []byte {
+	q := url.Values{}
+	if v.Source != "" {
+		q.Set("source", v.Source)
+	}
+	if v.Batch != "" {
+		q.Set("batch", v.Batch)
+	}
+	if v.Sum != "" {
+		q.Set("sum", v.Sum)
+	}
+	if v.Seq != 0 {
+		q.Set("seq", strconv.FormatUint(v.Seq, 10))
+	}
+	return []byte(q.Encode())
+}
+
+// DecodeLabelValue decodes the LabelValue from bytes, bad encoded values are ignored.
+func DecodeLabelValue(b []byte) (v LabelValue) {
+	if len(b) == 0 {
+		return v
+	}
+
+	q, _ := url.ParseQuery(string(b))
+	v.Source = q.Get("source")
+	v.Batch = q.Get("batch")
+	v.Sum = q.Get("sum")
+	v.Seq, _ = strconv.ParseUint(q.Get("seq"), 10, 64)
+	return v
+}
+
+// LabelEntry is a label with its metadata in the query response.
+type LabelEntry struct {
+	Label  string `json:"label"`
+	Source string `json:"source,omitempty"`
+	Batch  string `json:"batch,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+}
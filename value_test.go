@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestLabelValueEncode(t *testing.T) {
+	for _, v := range []LabelValue{{}, {Source: "a b&c.txt"}, {Source: "a.txt", Batch: "b1", Seq: 42, Sum: "x"}} {
+		if got := DecodeLabelValue(v.Encode()); got != v {
+			t.Errorf("decode(encode(%+v)) = %+v", v, got)
+		}
+	}
+	if len((LabelValue{}).Encode()) != 0 {
+		t.Error("empty LabelValue should be encoded as an empty value")
+	}
+}
+
+func TestNormalizeLabel(t *testing.T) {
+	composed, decomposed := "caf\u00e9", "cafe\u0301"
+
+	setGlobal(t, &NormalizeLabels, false)
+	if NormalizeLabel(composed) == NormalizeLabel(decomposed) {
+		t.Error("the labels should be distinct without the normalization")
+	}
+
+	setGlobal(t, &NormalizeLabels, true)
+	if a, b := NormalizeLabel(composed), NormalizeLabel(decomposed); a != b || a != composed {
+		t.Errorf("normalized labels %q and %q, want both %q", a, b, composed)
+	}
+}
+
+func TestLoadNormalizedLabels(t *testing.T) {
+	inTempDir(t)
+	setGlobal(t, &NormalizeLabels, true)
+	db := openTestDB(t, 4)
+	writeTestFile(t, "a.txt", "13800000001\n")
+
+	// the decomposed label on write, and the composed one on query, map to the same key.
+	load(t, db, "a.txt", url.PathEscape("cafe\u0301"), "")
+	load(t, db, "a.txt", url.PathEscape("caf\u00e9"), "")
+	if got := labelsOf(t, db, "13800000001"); len(got) != 1 || got[0] != "caf\u00e9" {
+		t.Errorf("labels %q, want the one composed label", got)
+	}
+
+	var res struct {
+		Total int `json:"total"`
+	}
+	mustRequest(t, db, http.MethodGet, "/mobiles/"+url.PathEscape("cafe\u0301")+"/sample", "", &res)
+	if res.Total != 1 {
+		t.Errorf("%d mobiles of the decomposed label, want 1 of the normalized key", res.Total)
+	}
+}